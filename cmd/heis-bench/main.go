@@ -0,0 +1,137 @@
+package main
+
+// heis-bench replays passenger traffic through a simulated elevator bank and reports how well each assigner serves it.
+//
+//	go run ./cmd/heis-bench -assigner reassign,nearest,max-wait -n 500 -rate 0.1
+//	go run ./cmd/heis-bench -trace morning.jsonl -assigner all
+//
+// A trace has one passenger per line: {"t": 12.5, "from": 0, "to": 3}, with t in seconds.
+
+import (
+	"flag"
+	"fmt"
+	cost "heis/src/cost_func"
+	"heis/src/logging"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+func main() {
+	traceFile := flag.String("trace", "", "Passenger trace to replay, synthetic traffic is generated if empty")
+	saveTrace := flag.String("save-trace", "", "Write the synthetic trace to this file")
+	numPassengers := flag.Int("n", 300, "Number of synthetic passengers")
+	rate := flag.Float64("rate", 0.1, "Synthetic passengers per second")
+	lobby := flag.Float64("lobby", 0.3, "Share of synthetic trips to or from floor 0")
+	seed := flag.Int64("seed", 1, "Seed for synthetic traffic")
+	numFloors := flag.Int("floors", 4, "Number of floors")
+	numCars := flag.Int("elevators", 3, "Number of elevators")
+	assigners := flag.String("assigner", "all", fmt.Sprintf("Comma separated assigners to compare, from %v, or all", cost.AssignerNames))
+	hysteresis := flag.Duration("hysteresis", 0, "Wrap every assigner in hysteresis with this margin, 0 to disable")
+	travelTime := flag.Duration("travel", 2500*time.Millisecond, "Travel time between two floors")
+	doorTime := flag.Duration("door", 3*time.Second, "Door open time")
+	tick := flag.Duration("tick", 50*time.Millisecond, "Simulation time step")
+	verbose := flag.Bool("v", false, "Show the elevator log while simulating")
+	flag.Parse()
+
+	if !*verbose {
+		logging.Setup(io.Discard, "text", slog.LevelInfo, "")
+	}
+
+	var trace []traceEntry
+	if *traceFile != "" {
+		var err error
+		trace, err = readTrace(*traceFile, *numFloors)
+		if err != nil {
+			fmt.Println("readTrace error: ", err)
+			os.Exit(1)
+		}
+	} else {
+		trace = syntheticTrace(rand.New(rand.NewSource(*seed)), *numPassengers, *rate, *lobby, *numFloors)
+		if *saveTrace != "" {
+			if err := writeTrace(*saveTrace, trace); err != nil {
+				fmt.Println("writeTrace error: ", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	names := strings.Split(*assigners, ",")
+	if *assigners == "all" {
+		names = nil
+		for _, name := range cost.AssignerNames {
+			if name != "external" { //Forking every tick is too slow, ask for it explicitly
+				names = append(names, name)
+			}
+		}
+	}
+
+	config := simConfig{
+		numFloors:   *numFloors,
+		numCars:     *numCars,
+		travelTime:  *travelTime,
+		doorTime:    *doorTime,
+		tick:        *tick,
+		maxOvertime: 30 * time.Minute,
+	}
+	hraConfig := cost.HRAConfig{DoorOpenDuration: *doorTime, TravelDuration: *travelTime}
+
+	var results []result
+	for _, name := range names {
+		assigner, err := cost.NewAssigner(strings.TrimSpace(name), hraConfig)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		assigner = cost.NewFallbackAssigner(assigner)
+		if *hysteresis > 0 {
+			assigner = cost.NewHysteresis(assigner, hraConfig, *hysteresis)
+		}
+
+		r := newSimulation(config, assigner, trace).run()
+		r.assigner = strings.TrimSpace(name)
+		results = append(results, r)
+	}
+
+	fmt.Printf("%d passengers, %d floors, %d elevators\n\n", len(trace), *numFloors, *numCars)
+	fmt.Printf("%-14s %9s %9s %9s %9s %7s %6s %9s %9s\n",
+		"assigner", "wait avg", "wait p95", "trip avg", "trip p95", "floors", "doors", "reassign", "unserved")
+	for _, r := range results {
+		fmt.Printf("%-14s %9s %9s %9s %9s %7d %6d %9d %9d\n",
+			r.assigner, seconds(average(r.waits)), seconds(percentile(r.waits, 0.95)),
+			seconds(average(r.journeys)), seconds(percentile(r.journeys, 0.95)),
+			r.floorsTraveled, r.doorCycles, r.reassignments, r.unserved)
+	}
+}
+
+func average(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, d := range durations {
+		sum += d
+	}
+	return sum / time.Duration(len(durations))
+}
+
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	index := int(float64(len(sorted))*p+0.999999) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index]
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
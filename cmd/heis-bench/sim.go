@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	cost "heis/src/cost_func"
+	"heis/src/elev"
+	"heis/src/elevio"
+	"time"
+)
+
+type simConfig struct {
+	numFloors   int
+	numCars     int
+	travelTime  time.Duration
+	doorTime    time.Duration
+	tick        time.Duration
+	maxOvertime time.Duration
+}
+
+type passenger struct {
+	entry    traceEntry
+	car      *car
+	boarded  time.Duration
+	alighted time.Duration
+	done     bool
+}
+
+// One simulated elevator, driven by the same elev functions as main, with the motor and door timer replaced by counters
+type car struct {
+	id         string
+	e          *elev.Elevator
+	traveling  bool
+	travelLeft time.Duration
+	doorLeft   time.Duration
+}
+
+type result struct {
+	assigner       string
+	waits          []time.Duration
+	journeys       []time.Duration
+	floorsTraveled int
+	doorCycles     int
+	reassignments  int
+	unserved       int
+	simTime        time.Duration
+}
+
+type simulation struct {
+	config     simConfig
+	assigner   cost.Assigner
+	cars       []*car
+	passengers []*passenger
+	next       int
+	now        time.Duration
+	assignedTo [][2]string
+	doorTimer  *time.Timer
+	result     result
+}
+
+func newSimulation(config simConfig, assigner cost.Assigner, trace []traceEntry) *simulation {
+	s := &simulation{
+		config:     config,
+		assigner:   assigner,
+		assignedTo: make([][2]string, config.numFloors),
+		doorTimer:  time.NewTimer(time.Hour),
+	}
+	s.doorTimer.Stop()
+	elevio.InitDetached(config.numFloors)
+
+	for i := 0; i < config.numCars; i++ {
+		e := &elev.Elevator{}
+		e.InitOrders(config.numFloors)
+		e.OtherNodes.Alive = make(map[string]bool) //Every car runs alone, the simulation plays the part of the network
+		e.OtherNodes.ID = fmt.Sprintf("car%d", i)
+		e.SetElevMotorDirection(elevio.MD_Stop)
+		s.cars = append(s.cars, &car{id: e.OtherNodes.ID, e: e})
+	}
+	for _, entry := range trace {
+		s.passengers = append(s.passengers, &passenger{entry: entry})
+	}
+	return s
+}
+
+func (s *simulation) run() result {
+	var lastArrival time.Duration
+	if len(s.passengers) > 0 {
+		lastArrival = s.passengers[len(s.passengers)-1].entry.arrival()
+	}
+
+	for ; ; s.now += s.config.tick {
+		s.arrivals()
+		s.assign()
+		for _, c := range s.cars {
+			if s.step(c) {
+				s.assign()
+			}
+		}
+		if s.next == len(s.passengers) && s.allDone() {
+			break
+		}
+		if s.now > lastArrival+s.config.maxOvertime {
+			break
+		}
+	}
+
+	s.result.simTime = s.now
+	for _, p := range s.passengers {
+		if !p.done {
+			s.result.unserved++
+			continue
+		}
+		s.result.waits = append(s.result.waits, p.boarded-p.entry.arrival())
+		s.result.journeys = append(s.result.journeys, p.alighted-p.entry.arrival())
+	}
+	return s.result
+}
+
+func (s *simulation) allDone() bool {
+	for _, p := range s.passengers {
+		if !p.done {
+			return false
+		}
+	}
+	return true
+}
+
+func hallButton(entry traceEntry) elevio.ButtonType {
+	if entry.To > entry.From {
+		return elevio.BT_HallUp
+	}
+	return elevio.BT_HallDown
+}
+
+// Passengers whose time has come press their hall button, which every car sees at once
+func (s *simulation) arrivals() {
+	for ; s.next < len(s.passengers) && s.passengers[s.next].entry.arrival() <= s.now; s.next++ {
+		entry := s.passengers[s.next].entry
+		for _, c := range s.cars {
+			c.e.UpdateElevatorOrder(elevio.ButtonEvent{Floor: entry.From, Button: hallButton(entry)})
+		}
+	}
+}
+
+// Same events as the floor sensor, motor and door timer in main, one tick at a time. Returns true if any order changed.
+func (s *simulation) step(c *car) bool {
+	hallBefore := snapshotHall(c.e)
+	cabBefore := append([]elev.OrderStatus(nil), c.e.Orders.ListCab...)
+	doorBefore := c.e.State.DoorOpen
+	reopened := false
+
+	switch {
+	case c.e.State.DoorOpen:
+		c.doorLeft -= s.config.tick
+		if c.doorLeft <= 0 {
+			extending := c.e.State.AnnouncementPending || c.e.State.Obstructed
+			c.e.DoorTimeHandler(s.doorTimer, s.config.doorTime)
+			if c.e.State.DoorOpen {
+				c.doorLeft = s.config.doorTime
+				reopened = !extending
+			}
+		}
+	case c.traveling:
+		c.travelLeft -= s.config.tick
+		if c.travelLeft <= 0 {
+			c.traveling = false
+			c.e.UpdateFloor(c.e.State.Floor + int(c.e.State.Direction))
+			s.result.floorsTraveled++
+			c.e.ExecuteOrder()
+		}
+	case c.e.ActiveOrders(): //The floor sensor keeps reporting while there are orders
+		c.e.ExecuteOrder()
+	}
+
+	if !c.e.State.DoorOpen && c.e.State.Direction != elevio.MD_Stop && !c.traveling {
+		c.traveling = true
+		c.travelLeft = s.config.travelTime
+	}
+	if (!doorBefore && c.e.State.DoorOpen) || reopened {
+		s.result.doorCycles++
+		c.doorLeft = s.config.doorTime
+	}
+
+	changed := false
+	floor := c.e.State.Floor
+	for button := 0; button < 2; button++ {
+		if hallBefore[floor][button] == elev.Order_Active && c.e.Orders.ListHall[floor][button] == elev.Order_PendingInactive {
+			s.board(c, floor, elevio.ButtonType(button))
+			for _, other := range s.cars { //Stands in for the consensus on clearing the order
+				other.e.Orders.ListHall[floor][button] = elev.Order_Inactive
+			}
+			changed = true
+		}
+	}
+	if cabBefore[floor] == elev.Order_Active && c.e.Orders.ListCab[floor] == elev.Order_Inactive {
+		s.alight(c, floor)
+		changed = true
+	}
+	return changed
+}
+
+func (s *simulation) board(c *car, floor int, button elevio.ButtonType) {
+	for _, p := range s.passengers[:s.next] {
+		if p.car != nil || p.done || p.entry.From != floor || hallButton(p.entry) != button {
+			continue
+		}
+		p.car = c
+		p.boarded = s.now
+		event := elevio.ButtonEvent{Floor: p.entry.To, Button: elevio.BT_Cab}
+		c.e.UpdateElevatorOrder(event)
+		c.e.GoingWrongway(&event)
+	}
+}
+
+func (s *simulation) alight(c *car, floor int) {
+	for _, p := range s.passengers[:s.next] {
+		if p.car == c && !p.done && p.entry.To == floor {
+			p.done = true
+			p.alighted = s.now
+		}
+	}
+}
+
+func snapshotHall(e *elev.Elevator) [][2]elev.OrderStatus {
+	hall := make([][2]elev.OrderStatus, len(e.Orders.ListHall))
+	for floor := range e.Orders.ListHall {
+		hall[floor] = [2]elev.OrderStatus{e.Orders.ListHall[floor][0], e.Orders.ListHall[floor][1]}
+	}
+	return hall
+}
+
+// Builds the input the same way main does, with order ages taken from the simulated clock
+func (s *simulation) hraInput() cost.HRAInput {
+	others := make(map[string]elev.ElevatorMessage)
+	for _, c := range s.cars[1:] {
+		statusCh := make(chan elev.ElevatorMessage, 1)
+		c.e.SendStatus(c.id, statusCh)
+		others[c.id] = <-statusCh
+	}
+	input := cost.MakeHRAInput(*s.cars[0].e, others)
+
+	for floor := range input.HallRequestAges {
+		input.HallRequestAges[floor] = [2]time.Duration{}
+	}
+	for _, c := range s.cars {
+		input.States[c.id] = withCabAges(input.States[c.id])
+	}
+	for _, p := range s.passengers[:s.next] {
+		switch {
+		case p.done:
+		case p.car == nil:
+			age := s.now - p.entry.arrival()
+			ages := &input.HallRequestAges[p.entry.From][hallButton(p.entry)]
+			if age > *ages {
+				*ages = age
+			}
+		default:
+			age := s.now - p.boarded
+			if ages := input.States[p.car.id].CabRequestAges; age > ages[p.entry.To] {
+				ages[p.entry.To] = age
+			}
+		}
+	}
+	return input
+}
+
+// Also drops the timing measured with the wall clock, every car runs with the timing in simConfig
+func withCabAges(state cost.HRAElevState) cost.HRAElevState {
+	state.CabRequestAges = make([]time.Duration, len(state.CabRequests))
+	state.TravelDuration = 0
+	state.DoorOpenDuration = 0
+	return state
+}
+
+func (s *simulation) assign() {
+	input := s.hraInput()
+	output := s.assigner.Assign(input)
+	if output == nil {
+		return
+	}
+	for _, c := range s.cars {
+		if assigned, ok := output[c.id]; ok {
+			c.e.Orders.Assigned = assigned
+		}
+	}
+
+	for floor := range s.assignedTo {
+		for button := 0; button < 2; button++ {
+			owner := ""
+			for _, c := range s.cars {
+				if c.e.Orders.Assigned[floor][button] {
+					owner = c.id
+				}
+			}
+			if input.HallRequests[floor][button] && s.assignedTo[floor][button] != "" && owner != s.assignedTo[floor][button] {
+				s.result.reassignments++
+			}
+			s.assignedTo[floor][button] = owner
+		}
+	}
+}
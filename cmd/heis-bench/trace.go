@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+// One passenger: presses the hall button at From at time T (seconds), and the cab button for To once inside.
+type traceEntry struct {
+	T    float64 `json:"t"`
+	From int     `json:"from"`
+	To   int     `json:"to"`
+}
+
+func (entry traceEntry) arrival() time.Duration {
+	return time.Duration(entry.T * float64(time.Second))
+}
+
+func readTrace(filename string, numFloors int) ([]traceEntry, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var trace []traceEntry
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry traceEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if entry.From < 0 || entry.From >= numFloors || entry.To < 0 || entry.To >= numFloors || entry.From == entry.To {
+			return nil, fmt.Errorf("line %d: invalid trip from %d to %d", line, entry.From, entry.To)
+		}
+		trace = append(trace, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(trace, func(i, j int) bool { return trace[i].T < trace[j].T })
+	return trace, nil
+}
+
+func writeTrace(filename string, trace []traceEntry) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	for _, entry := range trace {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Poisson arrivals with uniformly random trips. A share of the trips, given by lobby, start or end at floor 0.
+func syntheticTrace(rng *rand.Rand, numPassengers int, rate float64, lobby float64, numFloors int) []traceEntry {
+	trace := make([]traceEntry, 0, numPassengers)
+	t := 0.0
+	for i := 0; i < numPassengers; i++ {
+		t += rng.ExpFloat64() / rate
+		from := rng.Intn(numFloors)
+		to := rng.Intn(numFloors - 1)
+		if to >= from {
+			to++
+		}
+		if rng.Float64() < lobby {
+			if rng.Intn(2) == 0 {
+				from, to = 0, 1+rng.Intn(numFloors-1)
+			} else {
+				from, to = 1+rng.Intn(numFloors-1), 0
+			}
+		}
+		trace = append(trace, traceEntry{T: float64(int(t*1000)) / 1000, From: from, To: to})
+	}
+	return trace
+}
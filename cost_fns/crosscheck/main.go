@@ -0,0 +1,106 @@
+package main
+
+// Checks the Go port of the hall request assigner against the executable.
+// Inputs are either recorded by the elevator (heis -hra-record <file>) or randomly generated.
+// Run from the repository root: go run ./cost_fns/crosscheck -i hra_inputs.jsonl
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	cost "heis/src/cost_func"
+	"math/rand"
+	"os"
+	"time"
+)
+
+var behaviours = []string{"idle", "moving", "doorOpen"}
+var directions = []string{"down", "stop", "up"}
+
+func randomInput(rng *rand.Rand, numFloors int, numElevators int) cost.HRAInput {
+	input := cost.HRAInput{
+		HallRequests: make([][2]bool, numFloors),
+		States:       make(map[string]cost.HRAElevState),
+	}
+	for floor := range input.HallRequests {
+		input.HallRequests[floor] = [2]bool{rng.Intn(3) == 0, rng.Intn(3) == 0}
+	}
+	input.HallRequests[0][1] = false
+	input.HallRequests[numFloors-1][0] = false
+
+	for i := 0; i < numElevators; i++ {
+		state := cost.HRAElevState{
+			Behavior:    behaviours[rng.Intn(len(behaviours))],
+			Floor:       rng.Intn(numFloors),
+			Direction:   directions[rng.Intn(len(directions))],
+			CabRequests: make([]bool, numFloors),
+		}
+		for floor := range state.CabRequests {
+			state.CabRequests[floor] = rng.Intn(4) == 0
+		}
+		if state.Behavior == "moving" {
+			switch {
+			case state.Floor == 0:
+				state.Direction = "up"
+			case state.Floor == numFloors-1:
+				state.Direction = "down"
+			case state.Direction == "stop":
+				state.Direction = "up"
+			}
+		}
+		input.States[fmt.Sprintf("localhost:%d", 15657-i)] = state
+	}
+	return input
+}
+
+func main() {
+	inputFile := flag.String("i", "", "File with one recorded HRAInput JSON object per line")
+	numRandom := flag.Int("n", 1000, "Number of random inputs to check when no file is given")
+	numFloors := flag.Int("floors", 4, "Number of floors for random inputs")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "Seed for random inputs")
+	config := cost.DefaultHRAConfig
+	flag.DurationVar(&config.DoorOpenDuration, "doorOpenDuration", config.DoorOpenDuration, "Door open time")
+	flag.DurationVar(&config.TravelDuration, "travelDuration", config.TravelDuration, "Travel time between two floors")
+	flag.StringVar(&cost.HRAExecutableDir, "dir", cost.HRAExecutableDir, "Directory of the hall_request_assigner executable")
+	flag.Parse()
+
+	var inputs []cost.HRAInput
+	if *inputFile != "" {
+		file, err := os.Open(*inputFile)
+		if err != nil {
+			fmt.Println("os.Open error: ", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var input cost.HRAInput
+			if err := json.Unmarshal(scanner.Bytes(), &input); err != nil {
+				fmt.Println("json.Unmarshal error: ", err)
+				continue
+			}
+			inputs = append(inputs, input)
+		}
+	} else {
+		rng := rand.New(rand.NewSource(*seed))
+		fmt.Printf("Seed: %d\n", *seed)
+		for i := 0; i < *numRandom; i++ {
+			inputs = append(inputs, randomInput(rng, *numFloors, 1+rng.Intn(4)))
+		}
+	}
+
+	failed := 0
+	for i, input := range inputs {
+		if err := cost.CrossCheck(input, config); err != nil {
+			failed++
+			inputJSON, _ := json.Marshal(input)
+			fmt.Printf("Input %d: %v\n\tinput:    %s\n", i, err, inputJSON)
+		}
+	}
+	fmt.Printf("%d of %d inputs agree\n", len(inputs)-failed, len(inputs))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -1,158 +1,152 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	cost "heis/src/cost_func"
 	"heis/src/elev"
-	"heis/src/elevio"
+	"heis/src/logging"
 	"heis/src/network/bcast"
+	"heis/src/network/conn"
+	"heis/src/network/localip"
+	"heis/src/network/peers"
+	"heis/src/node"
+	"log/slog"
+	"math/rand"
+	"os"
+	"strings"
 	"time"
+	"unicode"
 )
 
 func main() {
-	const numFloors = 4
-
-	otherNodesMap := make(map[string]elev.ElevatorMessage) //Map to store messages from other nodes
-	lastSeenMap := make(map[string]time.Time)              //Map to note when node x last seen
-
-	timeOutTicker := time.NewTicker(500 * time.Millisecond)
-	nodeTimeout := 4 * time.Second
-
-	doorTimeOpen := 3 * time.Second
-	doorTimer := time.NewTimer(doorTimeOpen)
-	doorTimer.Stop()
-
-	obstructionLimit := 8 * time.Second
-	doorObstructedTimer := time.NewTimer(obstructionLimit)
-	doorObstructedTimer.Stop()
-
-	lastFloorChangeTime := time.Now()
-	motorWatchdog := time.NewTicker(1 * time.Second)
-
-	sendTicker := time.NewTicker(10 * time.Millisecond) //Send interval
-
-	localID := flag.Int("port", 15657, "UDP PORT")
+	localID := flag.Int("port", 15657, "Port of the elevator server on localhost")
+	serverAddress := flag.String("server", "", "Elevator server address, overrides -port")
+	idFlag := flag.String("id", "", "Node ID, if empty one is generated and saved in .heis-id-<port>, or .heis-id-<server> with -server")
+	bank := flag.String("bank", "", "Elevator bank this node belongs to, nodes in other banks are ignored")
+	bcastPort := flag.Int("bcast-port", 0, "UDP port for messages between nodes, 0 to derive it from the bank")
+	flag.DurationVar(&peers.Timeout, "node-timeout", 4*time.Second, "A node is considered dead when it has not been heard for this long")
+	flag.DurationVar(&peers.JoinDelay, "node-join-delay", peers.JoinDelay, "A node has to be heard steadily for this long before it is considered alive")
+	hraRecordFile := flag.String("hra-record", "", "Append every cost function input to this file, for cost_fns/crosscheck")
+	assignerName := flag.String("assigner", "reassign", fmt.Sprintf("Hall request assignment strategy, one of %v. %v need -coordinator", cost.AssignerNames, cost.CoordinatorAssigners))
+	hysteresis := flag.Duration("hysteresis", 0, "Only move a hall order to another elevator if it is served this much faster, 0 to disable. Needs -coordinator")
+	coordinatorMode := flag.Bool("coordinator", false, "Let the alive node with the lowest ID assign hall orders for everyone")
+	destinationPanel := flag.Bool("dest-panel", false, "Read destination orders as \"<origin> <destination>\" lines from the terminal")
+	carLetter := flag.String("car-letter", "", "Name of this car on the destination panels, like A. The node ID is shown if empty")
+	flag.BoolVar(&bcast.BinaryEncoding, "binary-wire", false, "Send status messages in the compact binary format instead of JSON")
+	flag.StringVar(&conn.Config.Mode, "net-mode", conn.Config.Mode, fmt.Sprintf("How messages are addressed, one of %s, %s or %s", conn.ModeBroadcast, conn.ModeMulticast, conn.ModeUnicast))
+	flag.StringVar(&conn.Config.Broadcast, "net-broadcast", conn.Config.Broadcast, "Broadcast address, like 10.100.23.255 for a directed subnet broadcast")
+	flag.StringVar(&conn.Config.Group, "net-group", conn.Config.Group, "Multicast group")
+	flag.StringVar(&conn.Config.Interface, "net-iface", "", "Network interface for multicast, or to take the broadcast address from")
+	flag.IntVar(&conn.Config.SendPort, "net-send-port", 0, "Send everything from this UDP port, so impairments can single out this node. 0 to send from the shared ports")
+	netPeers := flag.String("net-peers", "", "Comma separated hosts to send to in unicast mode")
+	impairments := flag.String("impair", "", "Impair received messages for testing, like \"loss=0.2 delay=50ms\", several separated by ;. Use peer=:<port> with -net-send-port to impair a single node")
+	impairControl := flag.Int("impair-control", 0, "Local UDP port that takes impairment commands while running, 0 to disable")
+	keyFile := flag.String("key-file", "", "File with the cluster key, every message is authenticated with it if set")
+	flag.DurationVar(&cost.ExternalTimeout, "hra-timeout", cost.ExternalTimeout, "Time limit for the hall_request_assigner executable")
+	metricsAddr := flag.String("metrics", "", "Serve Prometheus metrics on this address, like localhost:9100, empty to disable")
+	logFormat := flag.String("log-format", "text", "Log record format, text or json")
+	logLevel := flag.String("log-level", "info", "Lowest level logged, one of debug, info, warn or error")
+	logLevels := flag.String("log-levels", "", fmt.Sprintf("Levels for single subsystems, like \"net=debug,cost=warn\". Subsystems are %s, %s, %s and %s", logging.Main, logging.Elev, logging.Cost, logging.Net))
 	flag.Parse()
 
-	networkStatusOut := make(chan elev.ElevatorMessage)
-	networkStatusIn := make(chan elev.ElevatorMessage)
-
-	go bcast.Transmitter(20013, networkStatusOut)
-	go bcast.Receiver(20013, networkStatusIn)
-
-	address := fmt.Sprintf("localhost:%d", *localID)
-	elevio.Init(address, numFloors)
-
-	elevator := &elev.Elevator{}
-	elevator.CabInit(address, numFloors)
-
-	buttonEvents := make(chan elevio.ButtonEvent)
-	floorEvents := make(chan int)
-	obstructionEvents := make(chan bool)
-	stopEvents := make(chan bool)
-	buttonPressCh := make(chan bool, 1)
-
-	go elevio.PollButtons(buttonEvents)
-	go elevio.PollFloorSensor(floorEvents, buttonPressCh, elevator.ActiveOrders)
-	go elevio.PollObstructionSwitch(obstructionEvents)
-	go elevio.PollStopButton(stopEvents)
-
-	for {
-		runCost := false //Flag to run cost function
-
-		select {
-		case buttonEvent := <-buttonEvents:
-			elevator.UpdateElevatorOrder(buttonEvent)
-			buttonPressCh <- true
-			elevator.GoingWrongway(&buttonEvent)
-
-			runCost = true
-		case newFloor := <-floorEvents:
-			if newFloor != elevator.State.Floor {
-				lastFloorChangeTime = time.Now()
-				if elevator.State.Stuck {
-					elevator.State.Stuck = false
-					fmt.Printf("Motor drive recovered \n")
-				}
-			}
-			elevio.SetFloorIndicator(newFloor)
-			elevator.UpdateFloor(newFloor)
-
-			if !elevator.State.DoorOpen {
-				elevator.ExecuteOrder()
-				if elevator.State.DoorOpen {
-					fmt.Printf("Door opening \n")
-					doorTimer.Reset(doorTimeOpen)
-				}
-			}
-			runCost = true
-
-		case <-doorTimer.C:
-			elevator.DoorTimeHandler(doorTimer, doorTimeOpen)
-			runCost = true
-
-		case <-sendTicker.C:
-			if elevator.State.Stuck {
-				continue
-			}
-			elevator.SendStatus(address, networkStatusOut)
-		case msg := <-networkStatusIn:
-			if (msg.SenderID == address) || msg.MessageID <= otherNodesMap[msg.SenderID].MessageID || elevator.State.Stuck {
-				continue
-			}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		panic(err.Error())
+	}
+	if err := logging.Setup(os.Stdout, *logFormat, level, *logLevels); err != nil {
+		panic(err.Error())
+	}
 
-			lastSeenMap[msg.SenderID] = time.Now()
+	if *netPeers != "" {
+		conn.Config.Peers = strings.Split(*netPeers, ",")
+	}
+	if err := conn.Config.Validate(); err != nil {
+		panic(err.Error())
+	}
 
-			if !elevator.OtherNodes.Alive[msg.SenderID] {
-				elevator.OtherNodes.Alive[msg.SenderID] = true
-				fmt.Printf("Node %s connected \n", msg.SenderID)
-				runCost = true
+	if *impairments != "" || *impairControl != 0 {
+		conn.ImpairmentEnabled = true
+		for _, command := range strings.Split(*impairments, ";") {
+			if err := conn.ApplyImpairment(command); err != nil {
+				panic(err.Error())
 			}
+		}
+		if *impairControl != 0 {
+			go conn.ServeImpairmentControl(*impairControl)
+		}
+	}
 
-			stateChanged := elevator.StateChanged(msg, otherNodesMap)
-
-			otherNodesMap[msg.SenderID] = msg
-			elevator.CabBackupFunc(msg)
-			elevator.HallConsensus(msg, otherNodesMap)
+	if *keyFile != "" {
+		key, err := os.ReadFile(*keyFile)
+		if err != nil {
+			panic(err.Error())
+		}
+		bcast.Key = bytes.TrimSpace(key)
+	}
 
-			if stateChanged {
-				runCost = true
-			}
-		case <-timeOutTicker.C:
-			for id, lastTime := range lastSeenMap {
-				if elevator.OtherNodes.Alive[id] && time.Since(lastTime) > nodeTimeout {
-					elevator.OtherNodes.Alive[id] = false
-					fmt.Printf("Watchdog: Node %s timed out! Marking as dead.\n", id)
-					delete(otherNodesMap, id)
-					runCost = true
-				}
-			}
-		case <-doorObstructedTimer.C:
-			if elevator.State.Obstructed && elevator.State.DoorOpen {
-				fmt.Printf("Door stuck due to obstruction \n")
-				elevator.State.Stuck = true
-				elevator.SetElevMotorDirection(elevio.MD_Stop)
+	if *bcastPort == 0 {
+		*bcastPort = bankPort(*bank)
+	}
+	idFile := fmt.Sprintf(".heis-id-%d", *localID)
+	if *serverAddress == "" {
+		*serverAddress = fmt.Sprintf("localhost:%d", *localID)
+	} else {
+		idFile = ".heis-id-" + strings.Map(func(r rune) rune {
+			if r == '.' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
 			}
-		case <-motorWatchdog.C:
-			elevator.StuckHandler(&lastFloorChangeTime)
-
-		case obstruction := <-obstructionEvents:
-			elevator.ObstructionHandler(obstruction, doorObstructedTimer, obstructionLimit, doorTimer, doorTimeOpen)
+			return '_'
+		}, *serverAddress)
+	}
+	config := node.Config{
+		Server:        *serverAddress,
+		Bank:          *bank,
+		BcastPort:     *bcastPort,
+		Assigner:      *assignerName,
+		Hysteresis:    *hysteresis,
+		Coordinator:   *coordinatorMode,
+		CarLetter:     *carLetter,
+		HRARecordFile: *hraRecordFile,
+		MetricsAddr:   *metricsAddr,
+	}
+	if *destinationPanel {
+		config.DestinationPanel = os.Stdin
+	}
+	if err := config.Validate(); err != nil {
+		panic(err.Error())
+	}
 
-		case stopPressed := <-stopEvents:
-			if stopPressed {
-				elevio.SetStopLamp(true)
-				elevator.CabInit(address, numFloors)
-				elevio.SetStopLamp(false)
-			}
+	nodeID := *idFlag
+	if nodeID == "" {
+		prefix := "node"
+		if local, err := localip.Lookup(conn.Config.Interface); err == nil {
+			prefix = local.IP.String()
 		}
-		if runCost {
-			result := cost.CostFunc(cost.MakeHRAInput(*elevator, otherNodesMap))[address]
-			if result != nil {
-				elevator.Orders.Assigned = result
-			}
-			elevator.UpdateHallLights()
+		var err error
+		nodeID, err = elev.LoadOrCreateID(idFile, prefix)
+		if err != nil {
+			panic(err.Error())
 		}
 	}
+	config.ID = nodeID
+	config.Instance = rand.Uint32()
+	logging.SetNode(config.ID, config.Instance)
+	node.Setup(config)
+	node.Run(context.Background(), config)
+}
+
+// Every bank gets its own port, so banks on the same network don't even see each other's messages.
+// The default bank keeps the original port.
+func bankPort(bank string) int {
+	const defaultPort = 20013
+	if bank == "" {
+		return defaultPort
+	}
+	h := fnv.New32a()
+	h.Write([]byte(bank))
+	return defaultPort + 1 + int(h.Sum32()%1000)
 }
@@ -0,0 +1,247 @@
+package cost
+
+import (
+	"fmt"
+	"time"
+)
+
+// An Assigner decides which elevator serves each active hall request.
+// Every implementation gets the same HRAInput and returns the hall requests per elevator ID, or nil on failure.
+type Assigner interface {
+	Assign(input HRAInput) map[string][][2]bool
+}
+
+var AssignerNames = []string{"reassign", "external", "nearest", "time-to-idle", "time-to-serve", "max-wait"}
+
+// Assigners whose result depends on what they assigned before or on order ages only this node knows.
+// Nodes assigning for themselves with these would disagree, so they need one coordinator assigning for everyone.
+var CoordinatorAssigners = []string{"time-to-idle", "time-to-serve", "max-wait"}
+
+func NewAssigner(name string, config HRAConfig) (Assigner, error) {
+	switch name {
+	case "reassign":
+		return &FullReassignment{Config: config}, nil
+	case "external":
+		return &ExternalAssigner{Config: config}, nil
+	case "nearest":
+		return &NearestCar{}, nil
+	case "time-to-idle":
+		return &SingleAssignment{Config: config, cost: timeToIdle}, nil
+	case "time-to-serve":
+		return &SingleAssignment{Config: config, cost: timeToServe}, nil
+	case "max-wait":
+		return &MaxWait{Config: config}, nil
+	default:
+		return nil, fmt.Errorf("unknown assigner %q, must be one of %v", name, AssignerNames)
+	}
+}
+
+// Alternative 2 from cost_fns/README.md, every hall request is reassigned on every call
+type FullReassignment struct {
+	Config HRAConfig
+}
+
+func (a *FullReassignment) Assign(input HRAInput) map[string][][2]bool {
+	output, err := OptimalHallRequests(input, a.Config)
+	if err != nil {
+		logger.Error("OptimalHallRequests failed", "err", err)
+		return nil
+	}
+	return output
+}
+
+// Alternative 2 using the hall_request_assigner executable
+type ExternalAssigner struct {
+	Config HRAConfig
+}
+
+func (a *ExternalAssigner) Assign(input HRAInput) map[string][][2]bool {
+	output, err := ExternalCostFunc(input, a.Config)
+	if err != nil {
+		logger.Error("ExternalCostFunc failed", "err", err)
+		return nil
+	}
+	return output
+}
+
+// Every hall request goes to the elevator with the fewest floors to travel, counting one extra floor if it is heading away
+type NearestCar struct{}
+
+func (a *NearestCar) Assign(input HRAInput) map[string][][2]bool {
+	if err := validateHRAInput(input); err != nil {
+		logger.Error("NearestCar failed", "err", err)
+		return nil
+	}
+	ids := sortedIDs(input)
+	output := emptyAssignment(ids, len(input.HallRequests))
+
+	for floor := range input.HallRequests {
+		for button := 0; button < 2; button++ {
+			if !input.HallRequests[floor][button] {
+				continue
+			}
+			bestID := ""
+			bestDistance := 0
+			for _, id := range ids {
+				elevState := input.States[id]
+				distance := floor - elevState.Floor
+				if distance < 0 {
+					distance = -distance
+				}
+				dir := hraDirMap[elevState.Direction]
+				if (dir == 1 && floor < elevState.Floor) || (dir == -1 && floor > elevState.Floor) {
+					distance++
+				}
+				if bestID == "" || distance < bestDistance {
+					bestID = id
+					bestDistance = distance
+				}
+			}
+			output[bestID][floor][button] = true
+		}
+	}
+	return output
+}
+
+// Estimated time for an elevator to do the work in e, given its current behaviour
+type costFn func(e hraElevator, behaviour string, floor int, button int, config HRAConfig) time.Duration
+
+// Alternative 1 from cost_fns/README.md. A new hall request is given to the elevator with the lowest cost,
+// and stays with that elevator until it is served or the elevator disappears from the input.
+// Which elevator a request stays with is not shared between nodes, so only use it where one coordinator assigns for everyone.
+type SingleAssignment struct {
+	Config     HRAConfig
+	cost       costFn
+	assignedTo [][2]string
+}
+
+func (a *SingleAssignment) Assign(input HRAInput) map[string][][2]bool {
+	if err := validateHRAInput(input); err != nil {
+		logger.Error("SingleAssignment failed", "err", err)
+		return nil
+	}
+	numFloors := len(input.HallRequests)
+	if len(a.assignedTo) != numFloors {
+		a.assignedTo = make([][2]string, numFloors)
+	}
+
+	for floor := range a.assignedTo {
+		for button := 0; button < 2; button++ {
+			_, alive := input.States[a.assignedTo[floor][button]]
+			if !input.HallRequests[floor][button] || !alive {
+				a.assignedTo[floor][button] = ""
+			}
+		}
+	}
+
+	ids := sortedIDs(input)
+	for floor := range a.assignedTo {
+		for button := 0; button < 2; button++ {
+			if !input.HallRequests[floor][button] || a.assignedTo[floor][button] != "" {
+				continue
+			}
+			bestID := ""
+			var bestCost time.Duration
+			for _, id := range ids {
+				e := workload(input, a.assignedTo, id)
+				e.requests[floor][button] = true
+				cost := a.cost(e, input.States[id].Behavior, floor, button, a.Config.forElevator(input.States[id]))
+				if bestID == "" || cost < bestCost {
+					bestID = id
+					bestCost = cost
+				}
+			}
+			a.assignedTo[floor][button] = bestID
+		}
+	}
+
+	output := emptyAssignment(ids, numFloors)
+	for floor := range a.assignedTo {
+		for button := 0; button < 2; button++ {
+			if id := a.assignedTo[floor][button]; id != "" {
+				output[id][floor][button] = true
+			}
+		}
+	}
+	return output
+}
+
+// The hall requests assigned to elevator id together with its cab requests
+func workload(input HRAInput, assignedTo [][2]string, id string) hraElevator {
+	elevState := input.States[id]
+	e := hraElevator{
+		floor:     elevState.Floor,
+		direction: hraDirMap[elevState.Direction],
+		requests:  make([][3]bool, len(input.HallRequests)),
+	}
+	for floor := range e.requests {
+		e.requests[floor][hraHallUp] = assignedTo[floor][hraHallUp] == id
+		e.requests[floor][hraHallDown] = assignedTo[floor][hraHallDown] == id
+		e.requests[floor][hraCab] = elevState.CabRequests[floor]
+	}
+	return e
+}
+
+// Alternative 1.1, time until the elevator has served every request and is idle
+func timeToIdle(e hraElevator, behaviour string, floor int, button int, config HRAConfig) time.Duration {
+	return simulate(e, behaviour, config, nil)
+}
+
+// Alternative 1.2, time until the elevator has served the new request
+func timeToServe(e hraElevator, behaviour string, floor int, button int, config HRAConfig) time.Duration {
+	return simulate(e, behaviour, config, func(servedFloor int, servedButton int, at time.Duration) bool {
+		return servedFloor == floor && servedButton == button
+	})
+}
+
+// Runs the single elevator algorithm on e until it is idle, and returns how long that took.
+// onServed is called with the time every request is cleared, and the simulation stops early if it returns true.
+func simulate(e hraElevator, behaviour string, config HRAConfig, onServed func(floor int, button int, at time.Duration) bool) time.Duration {
+	var duration time.Duration
+	done := false
+	onCleared := func(button int) {
+		if onServed != nil && onServed(e.floor, button, duration) {
+			done = true
+		}
+	}
+
+	switch behaviour {
+	case "idle":
+		e.direction = e.chooseDirection()
+		if e.direction == 0 && !e.anyRequestsAtFloor() {
+			return duration
+		}
+	case "moving":
+		duration += config.TravelDuration / 2
+		e.floor += e.direction
+	case "doorOpen":
+		duration -= config.DoorOpenDuration / 2
+	}
+
+	for step := 0; step < 4*len(e.requests)+4; step++ { //Bound in case the algorithm never goes idle
+		if e.shouldStop() {
+			e = e.clearReqsAtFloor(onCleared)
+			if done {
+				return duration
+			}
+			duration += config.DoorOpenDuration
+			e.direction = e.chooseDirection()
+			if e.direction == 0 && !e.anyRequestsAtFloor() {
+				return duration
+			}
+		}
+		if e.direction != 0 {
+			e.floor += e.direction
+			duration += config.TravelDuration
+		}
+	}
+	return duration
+}
+
+func emptyAssignment(ids []string, numFloors int) map[string][][2]bool {
+	output := make(map[string][][2]bool)
+	for _, id := range ids {
+		output[id] = make([][2]bool, numFloors)
+	}
+	return output
+}
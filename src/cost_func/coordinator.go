@@ -0,0 +1,108 @@
+package cost
+
+import (
+	"heis/src/elev"
+)
+
+// Single coordinator mode. The alive node with the lowest ID computes the assignment for everyone and
+// broadcasts it with an increasing epoch. The others only use the latest epoch from the current coordinator,
+// and compute their own assignment while there is none. A coordinator that restarts starts over at epoch 1
+// with a new instance, so its messages are ordered by epoch only within one instance.
+type Coordinator struct {
+	LocalID   string
+	Bank      string
+	Instance  uint32
+	epoch     int
+	leader    string //Coordinator when latest was last checked
+	latest    elev.AssignmentMessage
+	hasLatest bool
+}
+
+func NewCoordinator(localID string, bank string, instance uint32) *Coordinator {
+	return &Coordinator{LocalID: localID, Bank: bank, Instance: instance}
+}
+
+func (c *Coordinator) Leader(alive map[string]bool) string {
+	leader := c.LocalID
+	for id, isAlive := range alive {
+		if isAlive && id < leader {
+			leader = id
+		}
+	}
+	return leader
+}
+
+func (c *Coordinator) IsLeader(alive map[string]bool) bool {
+	return c.Leader(alive) == c.LocalID
+}
+
+// Wraps a new assignment computed here in a message with the next epoch
+func (c *Coordinator) Publish(assigned map[string][][2]bool, assignedDest map[string][][]bool) elev.AssignmentMessage {
+	c.epoch++
+	if !anyDestinations(assignedDest) {
+		assignedDest = nil //Keeps the message small when destination dispatch is not in use
+	}
+	c.latest = elev.AssignmentMessage{
+		SenderID:     c.LocalID,
+		Bank:         c.Bank,
+		Instance:     c.Instance,
+		Epoch:        c.epoch,
+		Assigned:     assigned,
+		AssignedDest: assignedDest,
+	}
+	c.hasLatest = true
+	c.leader = c.LocalID
+	return c.latest
+}
+
+// Returns true if msg is from the current coordinator and newer than what we have
+func (c *Coordinator) Receive(msg elev.AssignmentMessage, alive map[string]bool) bool {
+	c.checkLeader(alive)
+	if msg.Bank != c.Bank || msg.SenderID == c.LocalID || msg.SenderID != c.leader {
+		return false
+	}
+	if c.hasLatest && msg.Instance == c.latest.Instance && msg.Epoch <= c.latest.Epoch {
+		return false
+	}
+	if !c.hasLatest || msg.Instance != c.latest.Instance {
+		logger.Info("Following coordinator", "coordinator", msg.SenderID, "incarnation", msg.Instance, "epoch", msg.Epoch)
+	}
+	if msg.Epoch > c.epoch {
+		c.epoch = msg.Epoch //So our own epochs continue from here if we take over
+	}
+	c.latest = msg
+	c.hasLatest = true
+	return true
+}
+
+// The latest assignment from the current coordinator, if there is one
+func (c *Coordinator) Latest(alive map[string]bool) (elev.AssignmentMessage, bool) {
+	c.checkLeader(alive)
+	if !c.hasLatest {
+		return elev.AssignmentMessage{}, false
+	}
+	return c.latest, true
+}
+
+// Forgets the latest assignment when the coordinator changes, so nothing from a coordinator that was lost
+// is used if it comes back
+func (c *Coordinator) checkLeader(alive map[string]bool) {
+	if leader := c.Leader(alive); leader != c.leader {
+		c.leader = leader
+		c.latest = elev.AssignmentMessage{}
+		c.hasLatest = false
+	}
+}
+
+func anyDestinations(assignedDest map[string][][]bool) bool {
+	for _, origins := range assignedDest {
+		for _, destinations := range origins {
+			for _, assigned := range destinations {
+				if assigned {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -1,13 +1,23 @@
 package cost
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"heis/src/elev"
+	"heis/src/logging"
 	"os/exec"
+	"reflect"
 	"runtime"
+	"time"
 )
 
+var logger = logging.For(logging.Cost)
+
+var HRAExecutableDir = "./cost_fns/hall_request_assigner/"
+
+var ExternalTimeout = 500 * time.Millisecond
+
 var dirMap = map[int]string{
 	1:  "up",
 	-1: "down",
@@ -21,16 +31,24 @@ var OrderBoolMap = map[elev.OrderStatus]bool{
 	elev.Order_Active:          true,
 }
 
+// The age, timing and destination fields are not part of the hall_request_assigner format, the executable ignores them
+
 type HRAElevState struct {
-	Behavior    string `json:"behaviour"`
-	Floor       int    `json:"floor"`
-	Direction   string `json:"direction"`
-	CabRequests []bool `json:"cabRequests"`
+	Behavior         string          `json:"behaviour"`
+	Floor            int             `json:"floor"`
+	Direction        string          `json:"direction"`
+	CabRequests      []bool          `json:"cabRequests"`
+	CabRequestAges   []time.Duration `json:"cabRequestAges,omitempty"`
+	TravelDuration   time.Duration   `json:"travelDuration,omitempty"`   //Measured by the elevator, 0 to use the shared HRAConfig
+	DoorOpenDuration time.Duration   `json:"doorOpenDuration,omitempty"` //Measured by the elevator, 0 to use the shared HRAConfig
+	DestRequests     [][]bool        `json:"destRequests,omitempty"`     //Destination orders the elevator has been given
 }
 
 type HRAInput struct {
-	HallRequests [][2]bool               `json:"hallRequests"`
-	States       map[string]HRAElevState `json:"states"`
+	HallRequests    [][2]bool               `json:"hallRequests"`
+	HallRequestAges [][2]time.Duration      `json:"hallRequestAges,omitempty"`
+	DestRequests    [][]bool                `json:"destRequests,omitempty"`
+	States          map[string]HRAElevState `json:"states"`
 }
 
 func makeHRAElevState(Node any) HRAElevState {
@@ -40,21 +58,36 @@ func makeHRAElevState(Node any) HRAElevState {
 		elevState.Behavior = nodetype.State.Behaviour
 		elevState.Floor = nodetype.State.Floor
 		elevState.Direction = dirMap[int(nodetype.State.Direction)]
+		elevState.TravelDuration = nodetype.Timing.TravelDuration.Truncate(time.Millisecond) //Milliseconds like in StatusMessage, so every node has the same input
+		elevState.DoorOpenDuration = nodetype.Timing.DoorOpenDuration.Truncate(time.Millisecond)
+		elevState.DestRequests = make([][]bool, len(nodetype.Orders.AssignedDest))
+		for origin := range nodetype.Orders.AssignedDest {
+			elevState.DestRequests[origin] = append([]bool(nil), nodetype.Orders.AssignedDest[origin]...)
+		}
 		elevState.CabRequests = make([]bool, len(nodetype.Orders.ListCab))
+		elevState.CabRequestAges = make([]time.Duration, len(nodetype.Orders.ListCab))
 		for floor := 0; floor < len(nodetype.Orders.ListCab); floor++ {
 			elevState.CabRequests[floor] = OrderBoolMap[nodetype.Orders.ListCab[floor]]
+			elevState.CabRequestAges[floor] = elev.OrderAge(nodetype.Orders.TimeCab[floor])
 		}
 
 	case elev.ElevatorMessage:
 		elevState.Behavior = nodetype.Behaviour
 		elevState.Floor = nodetype.CurrentFloor
 		elevState.Direction = dirMap[int(nodetype.Direction)]
+		elevState.TravelDuration = time.Duration(nodetype.TravelTime) * time.Millisecond
+		elevState.DoorOpenDuration = time.Duration(nodetype.DoorOpenTime) * time.Millisecond
+		elevState.DestRequests = nodetype.AssignedDest
 		elevState.CabRequests = make([]bool, len(nodetype.OrderListCab))
+		elevState.CabRequestAges = make([]time.Duration, len(nodetype.OrderListCab))
 		for floor := 0; floor < len(nodetype.OrderListCab); floor++ {
 			elevState.CabRequests[floor] = OrderBoolMap[nodetype.OrderListCab[floor]]
+			if floor < len(nodetype.OrderAgeCab) {
+				elevState.CabRequestAges[floor] = time.Duration(nodetype.OrderAgeCab[floor]) * time.Millisecond
+			}
 		}
 	default:
-		fmt.Printf("Unsupported Node type")
+		logger.Error("Unsupported node type", "type", fmt.Sprintf("%T", nodetype))
 	}
 	return *elevState
 }
@@ -63,10 +96,20 @@ func MakeHRAInput(localNode elev.Elevator, otherNodes map[string]elev.ElevatorMe
 	HRAInput := &HRAInput{}
 	HRAInput.States = make(map[string]HRAElevState)
 	HRAInput.HallRequests = make([][2]bool, len(localNode.Orders.ListHall))
+	HRAInput.HallRequestAges = make([][2]time.Duration, len(localNode.Orders.ListHall))
 
 	for floor := 0; floor < len(localNode.Orders.ListHall); floor++ {
 		for button := 0; button < 2; button++ {
 			HRAInput.HallRequests[floor][button] = OrderBoolMap[localNode.Orders.ListHall[floor][button]]
+			HRAInput.HallRequestAges[floor][button] = elev.OrderAge(localNode.Orders.TimeHall[floor][button])
+		}
+	}
+
+	HRAInput.DestRequests = make([][]bool, len(localNode.Orders.ListDest))
+	for origin := range localNode.Orders.ListDest {
+		HRAInput.DestRequests[origin] = make([]bool, len(localNode.Orders.ListDest[origin]))
+		for destination := range localNode.Orders.ListDest[origin] {
+			HRAInput.DestRequests[origin][destination] = OrderBoolMap[localNode.Orders.ListDest[origin][destination]]
 		}
 	}
 
@@ -78,7 +121,10 @@ func MakeHRAInput(localNode elev.Elevator, otherNodes map[string]elev.ElevatorMe
 	return *HRAInput
 }
 
-func CostFunc(input HRAInput) map[string][][2]bool {
+// Runs the hall_request_assigner executable, kept so the Go port can be checked against it.
+// The executable is killed if it has not answered within ExternalTimeout.
+// It only takes one timing for all elevators, so measured per elevator timing is not used.
+func ExternalCostFunc(input HRAInput, config HRAConfig) (map[string][][2]bool, error) {
 
 	hraExecutable := ""
 	switch runtime.GOOS {
@@ -89,34 +135,97 @@ func CostFunc(input HRAInput) map[string][][2]bool {
 	case "darwin":
 		hraExecutable = "hall_request_assigner_mac"
 	default:
-		panic("OS not supported")
+		return nil, fmt.Errorf("OS not supported")
 	}
 
 	jsonBytes, err := json.Marshal(input)
 	if err != nil {
-		fmt.Println("json.Marshal error: ", err)
-		return nil
+		return nil, fmt.Errorf("json.Marshal error: %v", err)
 	}
 
-	ret, err := exec.Command("./cost_fns/hall_request_assigner/"+hraExecutable, "-i", string(jsonBytes)).CombinedOutput()
+	ctx, cancel := context.WithTimeout(context.Background(), ExternalTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, HRAExecutableDir+hraExecutable,
+		"--doorOpenDuration", fmt.Sprint(config.DoorOpenDuration.Milliseconds()),
+		"--travelDuration", fmt.Sprint(config.TravelDuration.Milliseconds()),
+		"-i", string(jsonBytes))
+	cmd.WaitDelay = 100 * time.Millisecond //Don't wait for children of a killed process to close the output
+	ret, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("exec.Command timed out after %v", ExternalTimeout)
+	}
 	if err != nil {
-		fmt.Println("exec.Command error: ", err)
-		fmt.Println(string(ret))
-		return nil
+		return nil, fmt.Errorf("exec.Command error: %v: %s", err, ret)
 	}
 
-	output := new(map[string][][2]bool)
+	output := make(map[string][][2]bool)
 	err = json.Unmarshal(ret, &output)
 	if err != nil {
-		fmt.Println("json.Unmarshal error: ", err)
-		return nil
+		return nil, fmt.Errorf("json.Unmarshal error: %v: %s", err, ret)
 	}
-	/*
-		fmt.Printf("output: \n")
-		for k, v := range *output {
-			fmt.Printf("%6v :  %+v\n", k, v)
+	if err := validateAssignment(input, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// Checks that every active hall request is given to exactly one of the elevators in the input, and nothing else is
+func validateAssignment(input HRAInput, output map[string][][2]bool) error {
+	if len(output) != len(input.States) {
+		return fmt.Errorf("assignment has %d elevators, expected %d", len(output), len(input.States))
+	}
+	for id, hallRequests := range output {
+		if _, exists := input.States[id]; !exists {
+			return fmt.Errorf("assignment has unknown elevator %s", id)
+		}
+		if len(hallRequests) != len(input.HallRequests) {
+			return fmt.Errorf("assignment for %s has %d floors, expected %d", id, len(hallRequests), len(input.HallRequests))
 		}
-	*/
-	return *output
+	}
+	for floor := range input.HallRequests {
+		for button := 0; button < 2; button++ {
+			assigned := 0
+			for _, hallRequests := range output {
+				if hallRequests[floor][button] {
+					assigned++
+				}
+			}
+			if input.HallRequests[floor][button] && assigned != 1 {
+				return fmt.Errorf("hall request %d %s assigned to %d elevators", floor, buttonNames[button], assigned)
+			}
+			if !input.HallRequests[floor][button] && assigned != 0 {
+				return fmt.Errorf("inactive hall request %d %s was assigned", floor, buttonNames[button])
+			}
+		}
+	}
+	return nil
+}
 
+// Runs both the Go port and the executable on the same input and reports any difference.
+// Per elevator timing is left out, since the executable can't use it.
+func CrossCheck(input HRAInput, config HRAConfig) error {
+	input = withSharedTiming(input)
+	native, err := OptimalHallRequests(input, config)
+	if err != nil {
+		return fmt.Errorf("native assigner failed: %v", err)
+	}
+	external, err := ExternalCostFunc(input, config)
+	if err != nil {
+		return fmt.Errorf("external assigner failed: %v", err)
+	}
+	if !reflect.DeepEqual(native, external) {
+		return fmt.Errorf("assignments differ\n\tnative:   %v\n\texternal: %v", native, external)
+	}
+	return nil
+}
+
+func withSharedTiming(input HRAInput) HRAInput {
+	states := make(map[string]HRAElevState, len(input.States))
+	for id, state := range input.States {
+		state.TravelDuration = 0
+		state.DoorOpenDuration = 0
+		states[id] = state
+	}
+	input.States = states
+	return input
 }
@@ -0,0 +1,109 @@
+package cost
+
+import (
+	"heis/src/elev"
+	"time"
+)
+
+// Assigns destination orders on top of the hall requests each elevator already has.
+// An order stays with the elevator it was given to, the passenger has been told to take that car,
+// and only moves when that elevator is no longer in the input. The others are taken one at a time and given
+// to the elevator whose time to idle grows the least, so passengers going to the same floor end up sharing a car.
+func AssignDestinations(input HRAInput, hallAssignment map[string][][2]bool, config HRAConfig) map[string][][]bool {
+	if err := validateHRAInput(input); err != nil {
+		logger.Error("AssignDestinations failed", "err", err)
+		return nil
+	}
+	ids := sortedIDs(input)
+	numFloors := len(input.HallRequests)
+
+	output := make(map[string][][]bool)
+	workloads := make(map[string]hraElevator)
+	idleTimes := make(map[string]time.Duration)
+	for _, id := range ids {
+		output[id] = make([][]bool, numFloors)
+		for origin := range output[id] {
+			output[id][origin] = make([]bool, numFloors)
+		}
+		assignedTo := make([][2]string, numFloors)
+		for floor, hallRequests := range hallAssignment[id] {
+			for button := 0; button < 2; button++ {
+				if hallRequests[button] && floor < numFloors {
+					assignedTo[floor][button] = id
+				}
+			}
+		}
+		workloads[id] = workload(input, assignedTo, id)
+	}
+
+	active := func(origin, destination int) bool {
+		return origin < len(input.DestRequests) && destination < len(input.DestRequests[origin]) && input.DestRequests[origin][destination] &&
+			origin < numFloors && destination < numFloors && origin != destination
+	}
+	kept := make(map[[2]int]bool)
+	for _, id := range ids {
+		for origin, destinations := range input.States[id].DestRequests {
+			for destination, given := range destinations {
+				if !given || !active(origin, destination) || kept[[2]int{origin, destination}] {
+					continue
+				}
+				kept[[2]int{origin, destination}] = true
+				workloads[id] = withDestination(workloads[id], origin, destination)
+				output[id][origin][destination] = true
+			}
+		}
+	}
+	for _, id := range ids {
+		idleTimes[id] = timeToIdle(workloads[id], input.States[id].Behavior, 0, 0, config.forElevator(input.States[id]))
+	}
+
+	for origin := range input.DestRequests {
+		for destination := range input.DestRequests[origin] {
+			if !active(origin, destination) || kept[[2]int{origin, destination}] {
+				continue
+			}
+
+			bestID := ""
+			var bestIncrease, bestIdle time.Duration
+			var bestWorkload hraElevator
+			for _, id := range ids {
+				e := withDestination(workloads[id], origin, destination)
+				idle := timeToIdle(e, input.States[id].Behavior, 0, 0, config.forElevator(input.States[id]))
+				if increase := idle - idleTimes[id]; bestID == "" || increase < bestIncrease {
+					bestID, bestIncrease, bestIdle, bestWorkload = id, increase, idle, e
+				}
+			}
+			workloads[bestID] = bestWorkload
+			idleTimes[bestID] = bestIdle
+			output[bestID][origin][destination] = true
+		}
+	}
+	return output
+}
+
+// A copy of the workload with a stop at origin in the direction of destination, and a cab request there
+func withDestination(e hraElevator, origin int, destination int) hraElevator {
+	button := hraHallUp
+	if destination < origin {
+		button = hraHallDown
+	}
+	e.requests = append([][3]bool(nil), e.requests...)
+	e.requests[origin][button] = true
+	e.requests[destination][hraCab] = true
+	return e
+}
+
+// The letter every node has been given for its car, or its ID if it has none, so a passenger is never
+// told a name that later belongs to another car
+func CarLetters(localNode elev.Elevator, otherNodes map[string]elev.ElevatorMessage) map[string]string {
+	letters := map[string]string{localNode.OtherNodes.ID: localNode.OtherNodes.CarLetter}
+	for id, status := range otherNodes {
+		letters[id] = status.CarLetter
+	}
+	for id, letter := range letters {
+		if letter == "" {
+			letters[id] = id
+		}
+	}
+	return letters
+}
@@ -0,0 +1,65 @@
+package cost
+
+import (
+	"fmt"
+	"time"
+)
+
+const fallbackRetryInterval = 5 * time.Second
+
+type Health struct {
+	Healthy      bool
+	Failures     int
+	LastError    string
+	LastDuration time.Duration
+}
+
+// Runs Primary, and uses Fallback whenever Primary fails or returns an invalid assignment.
+// After a failure Primary is left alone for a while, so a hanging executable does not block every event.
+type FallbackAssigner struct {
+	Primary    Assigner
+	Fallback   Assigner
+	health     Health
+	retryAfter time.Time
+}
+
+func NewFallbackAssigner(primary Assigner) *FallbackAssigner {
+	return &FallbackAssigner{
+		Primary:  primary,
+		Fallback: &NearestCar{},
+		health:   Health{Healthy: true},
+	}
+}
+
+func (f *FallbackAssigner) Health() Health {
+	return f.health
+}
+
+func (f *FallbackAssigner) Assign(input HRAInput) map[string][][2]bool {
+	if f.health.Healthy || time.Now().After(f.retryAfter) {
+		start := time.Now()
+		output := f.Primary.Assign(input)
+		f.health.LastDuration = time.Since(start)
+
+		err := fmt.Errorf("assigner returned nothing")
+		if output != nil {
+			err = validateAssignment(input, output)
+		}
+		if err == nil {
+			if !f.health.Healthy {
+				logger.Info("Assigner recovered", "failures", f.health.Failures)
+			}
+			f.health.Healthy = true
+			return output
+		}
+
+		if f.health.Healthy {
+			logger.Warn("Assigner failed, using fallback", "err", err)
+		}
+		f.health.Healthy = false
+		f.health.Failures++
+		f.health.LastError = err.Error()
+		f.retryAfter = time.Now().Add(fallbackRetryInterval)
+	}
+	return f.Fallback.Assign(input)
+}
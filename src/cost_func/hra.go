@@ -0,0 +1,414 @@
+package cost
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Go port of optimal_hall_requests.d from cost_fns/hall_request_assigner.
+// Takes the same input and gives the same output as the executable, without forking a process.
+
+type HRAConfig struct {
+	DoorOpenDuration time.Duration
+	TravelDuration   time.Duration
+}
+
+var DefaultHRAConfig = HRAConfig{
+	DoorOpenDuration: 3000 * time.Millisecond, //Same defaults as config.d
+	TravelDuration:   2500 * time.Millisecond,
+}
+
+// The config for one elevator, with the timing it has measured itself in place of the shared one
+func (config HRAConfig) forElevator(state HRAElevState) HRAConfig {
+	if state.TravelDuration > 0 {
+		config.TravelDuration = state.TravelDuration
+	}
+	if state.DoorOpenDuration > 0 {
+		config.DoorOpenDuration = state.DoorOpenDuration
+	}
+	return config
+}
+
+const (
+	hraHallUp   = 0
+	hraHallDown = 1
+	hraCab      = 2
+)
+
+type hraReq struct {
+	active     bool
+	assignedTo string
+}
+
+type hraLocalState struct {
+	behaviour   string
+	floor       int
+	direction   int
+	cabRequests []bool
+}
+
+type hraState struct {
+	id     string
+	state  hraLocalState
+	time   time.Duration
+	config HRAConfig
+}
+
+type hraElevator struct {
+	floor     int
+	direction int
+	requests  [][3]bool
+}
+
+var hraDirMap = map[string]int{
+	"up":   1,
+	"down": -1,
+	"stop": 0,
+}
+
+func OptimalHallRequests(input HRAInput, config HRAConfig) (map[string][][2]bool, error) {
+	numFloors := len(input.HallRequests)
+	ids := sortedIDs(input)
+
+	if err := validateHRAInput(input); err != nil {
+		return nil, err
+	}
+
+	states := make([]hraState, len(ids))
+	for i, id := range ids {
+		elevState := input.States[id]
+		cabRequests := make([]bool, numFloors)
+		copy(cabRequests, elevState.CabRequests)
+		states[i] = hraState{
+			id: id,
+			state: hraLocalState{
+				behaviour:   elevState.Behavior,
+				floor:       elevState.Floor,
+				direction:   hraDirMap[elevState.Direction],
+				cabRequests: cabRequests,
+			},
+			time:   time.Duration(i) * time.Microsecond, //Tiebreak, lowest ID wins
+			config: config.forElevator(elevState),
+		}
+	}
+
+	reqs := make([][2]hraReq, numFloors)
+	for floor := range input.HallRequests {
+		for button := 0; button < 2; button++ {
+			reqs[floor][button].active = input.HallRequests[floor][button]
+		}
+	}
+
+	for i := range states {
+		performInitialMove(&states[i], reqs)
+	}
+
+	for {
+		sort.Slice(states, func(i, j int) bool { return states[i].time < states[j].time })
+
+		done := !anyUnassigned(reqs)
+		if unvisitedAreImmediatelyAssignable(reqs, states) {
+			assignImmediate(reqs, states)
+			done = true
+		}
+		if done {
+			break
+		}
+		performSingleMove(&states[0], reqs)
+	}
+
+	result := make(map[string][][2]bool)
+	for _, id := range ids {
+		result[id] = make([][2]bool, numFloors)
+	}
+	for floor := range reqs {
+		for button := 0; button < 2; button++ {
+			if reqs[floor][button].active {
+				result[reqs[floor][button].assignedTo][floor][button] = true
+			}
+		}
+	}
+	return result, nil
+}
+
+func sortedIDs(input HRAInput) []string {
+	ids := make([]string, 0, len(input.States))
+	for id := range input.States {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Same checks as the contract on optimalHallRequests in the D code
+func validateHRAInput(input HRAInput) error {
+	numFloors := len(input.HallRequests)
+	if len(input.States) == 0 {
+		return fmt.Errorf("no elevator states provided")
+	}
+	for id, elevState := range input.States {
+		dir, ok := hraDirMap[elevState.Direction]
+		if !ok {
+			return fmt.Errorf("elevator %s has invalid direction %q", id, elevState.Direction)
+		}
+		switch elevState.Behavior {
+		case "idle", "moving", "doorOpen":
+		default:
+			return fmt.Errorf("elevator %s has invalid behaviour %q", id, elevState.Behavior)
+		}
+		if len(elevState.CabRequests) != numFloors {
+			return fmt.Errorf("hall and cab requests do not all have the same length")
+		}
+		if elevState.Floor < 0 || elevState.Floor >= numFloors {
+			return fmt.Errorf("elevator %s is at an invalid floor", id)
+		}
+		if elevState.Behavior == "moving" && (elevState.Floor+dir < 0 || elevState.Floor+dir >= numFloors) {
+			return fmt.Errorf("elevator %s is moving away from an end floor", id)
+		}
+	}
+	return nil
+}
+
+func isUnassigned(r hraReq) bool {
+	return r.active && r.assignedTo == ""
+}
+
+func anyUnassigned(reqs [][2]hraReq) bool {
+	for floor := range reqs {
+		for button := 0; button < 2; button++ {
+			if isUnassigned(reqs[floor][button]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func anyTrue(list []bool) bool {
+	for _, v := range list {
+		if v {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *hraState) withUnassignedReqs(reqs [][2]hraReq) hraElevator {
+	e := hraElevator{
+		floor:     s.state.floor,
+		direction: s.state.direction,
+		requests:  make([][3]bool, len(reqs)),
+	}
+	for floor := range reqs {
+		e.requests[floor][hraHallUp] = isUnassigned(reqs[floor][hraHallUp])
+		e.requests[floor][hraHallDown] = isUnassigned(reqs[floor][hraHallDown])
+		e.requests[floor][hraCab] = s.state.cabRequests[floor]
+	}
+	return e
+}
+
+func performInitialMove(s *hraState, reqs [][2]hraReq) {
+	switch s.state.behaviour {
+	case "doorOpen", "idle":
+		if s.state.behaviour == "doorOpen" {
+			s.time += s.config.DoorOpenDuration / 2
+		}
+		for button := 0; button < 2; button++ {
+			if reqs[s.state.floor][button].active {
+				reqs[s.state.floor][button].assignedTo = s.id
+				s.time += s.config.DoorOpenDuration
+			}
+		}
+	case "moving":
+		s.state.floor += s.state.direction
+		s.time += s.config.TravelDuration / 2
+	}
+}
+
+func performSingleMove(s *hraState, reqs [][2]hraReq) {
+	e := s.withUnassignedReqs(reqs)
+
+	onClearRequest := func(button int) {
+		switch button {
+		case hraHallUp, hraHallDown:
+			reqs[s.state.floor][button].assignedTo = s.id
+		case hraCab:
+			s.state.cabRequests[s.state.floor] = false
+		}
+	}
+
+	switch s.state.behaviour {
+	case "moving":
+		if e.shouldStop() {
+			s.state.behaviour = "doorOpen"
+			s.time += s.config.DoorOpenDuration
+			e.clearReqsAtFloor(onClearRequest)
+		} else {
+			s.state.floor += s.state.direction
+			s.time += s.config.TravelDuration
+		}
+	case "idle", "doorOpen":
+		s.state.direction = e.chooseDirection()
+		if s.state.direction == 0 {
+			if e.anyRequestsAtFloor() {
+				e.clearReqsAtFloor(onClearRequest)
+				s.time += s.config.DoorOpenDuration
+				s.state.behaviour = "doorOpen"
+			} else {
+				s.state.behaviour = "idle"
+			}
+		} else {
+			s.state.behaviour = "moving"
+			s.state.floor += s.state.direction
+			s.time += s.config.TravelDuration
+		}
+	}
+}
+
+// No remaining cab requests, no floors with multiple hall requests, and all unvisited hall requests are at floors with elevators
+func unvisitedAreImmediatelyAssignable(reqs [][2]hraReq, states []hraState) bool {
+	for _, s := range states {
+		if anyTrue(s.state.cabRequests) {
+			return false
+		}
+	}
+	for floor := range reqs {
+		if reqs[floor][hraHallUp].active && reqs[floor][hraHallDown].active {
+			return false
+		}
+		for button := 0; button < 2; button++ {
+			if !isUnassigned(reqs[floor][button]) {
+				continue
+			}
+			elevatorAtFloor := false
+			for _, s := range states {
+				if s.state.floor == floor && !anyTrue(s.state.cabRequests) {
+					elevatorAtFloor = true
+					break
+				}
+			}
+			if !elevatorAtFloor {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func assignImmediate(reqs [][2]hraReq, states []hraState) {
+	for floor := range reqs {
+		for button := 0; button < 2; button++ {
+			for i := range states {
+				if isUnassigned(reqs[floor][button]) && states[i].state.floor == floor && !anyTrue(states[i].state.cabRequests) {
+					reqs[floor][button].assignedTo = states[i].id
+					states[i].time += states[i].config.DoorOpenDuration
+				}
+			}
+		}
+	}
+}
+
+func (e hraElevator) requestsAbove() bool {
+	for floor := e.floor + 1; floor < len(e.requests); floor++ {
+		for button := 0; button < 3; button++ {
+			if e.requests[floor][button] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e hraElevator) requestsBelow() bool {
+	for floor := 0; floor < e.floor; floor++ {
+		for button := 0; button < 3; button++ {
+			if e.requests[floor][button] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e hraElevator) anyRequestsAtFloor() bool {
+	for button := 0; button < 3; button++ {
+		if e.requests[e.floor][button] {
+			return true
+		}
+	}
+	return false
+}
+
+func (e hraElevator) shouldStop() bool {
+	endFloor := e.floor == 0 || e.floor == len(e.requests)-1
+	switch e.direction {
+	case 1:
+		return e.requests[e.floor][hraHallUp] || e.requests[e.floor][hraCab] || !e.requestsAbove() || endFloor
+	case -1:
+		return e.requests[e.floor][hraHallDown] || e.requests[e.floor][hraCab] || !e.requestsBelow() || endFloor
+	default:
+		return true
+	}
+}
+
+func (e hraElevator) chooseDirection() int {
+	switch e.direction {
+	case 1:
+		switch {
+		case e.requestsAbove():
+			return 1
+		case e.anyRequestsAtFloor():
+			return 0
+		case e.requestsBelow():
+			return -1
+		}
+	default:
+		switch {
+		case e.requestsBelow():
+			return -1
+		case e.anyRequestsAtFloor():
+			return 0
+		case e.requestsAbove():
+			return 1
+		}
+	}
+	return 0
+}
+
+// Clears requests in the direction of travel, calling onClearedRequest for every request that was set.
+// Returns the elevator without the cleared requests, e itself is left untouched.
+func (e hraElevator) clearReqsAtFloor(onClearedRequest func(button int)) hraElevator {
+	requests := make([][3]bool, len(e.requests))
+	copy(requests, e.requests)
+	e2 := hraElevator{floor: e.floor, direction: e.direction, requests: requests}
+
+	clearReq := func(button int) {
+		if e2.requests[e2.floor][button] {
+			if onClearedRequest != nil {
+				onClearedRequest(button)
+			}
+			e2.requests[e2.floor][button] = false
+		}
+	}
+
+	clearReq(hraCab)
+	switch e.direction {
+	case 1:
+		if e2.requests[e2.floor][hraHallUp] {
+			clearReq(hraHallUp)
+		} else if !e2.requestsAbove() {
+			clearReq(hraHallDown)
+		}
+	case -1:
+		if e2.requests[e2.floor][hraHallDown] {
+			clearReq(hraHallDown)
+		} else if !e2.requestsBelow() {
+			clearReq(hraHallUp)
+		}
+	default:
+		clearReq(hraHallUp)
+		clearReq(hraHallDown)
+	}
+	return e2
+}
@@ -0,0 +1,188 @@
+package cost
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The unittest blocks of optimal_hall_requests.d, with the default config.d timing
+
+func state(behaviour string, floor int, direction string, cab ...bool) HRAElevState {
+	return HRAElevState{Behavior: behaviour, Floor: floor, Direction: direction, CabRequests: cab}
+}
+
+func TestOptimalHallRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		states map[string]HRAElevState
+		hall   [][2]bool
+		want   map[string][][2]bool
+	}{
+		{
+			name: "idle elevator one floor away gets the order over busy ones",
+			states: map[string]HRAElevState{
+				"1": state("idle", 0, "stop", false, false, false, false),
+				"2": state("doorOpen", 3, "down", true, false, false, false),
+				"3": state("moving", 2, "up", true, false, false, true),
+			},
+			hall: [][2]bool{{false, false}, {true, false}, {false, false}, {false, false}},
+			want: map[string][][2]bool{
+				"1": {{false, false}, {true, false}, {false, false}, {false, false}},
+				"2": {{false, false}, {false, false}, {false, false}, {false, false}},
+				"3": {{false, false}, {false, false}, {false, false}, {false, false}},
+			},
+		},
+		{
+			name: "elevators from each end stop at the closest order, even in the wrong direction",
+			states: map[string]HRAElevState{
+				"1": state("idle", 0, "stop", false, false, false, false),
+				"2": state("idle", 3, "stop", false, false, false, false),
+			},
+			hall: [][2]bool{{false, false}, {false, true}, {true, false}, {false, false}},
+			want: map[string][][2]bool{
+				"1": {{false, false}, {false, true}, {false, false}, {false, false}},
+				"2": {{false, false}, {false, false}, {true, false}, {false, false}},
+			},
+		},
+		{
+			name: "same as above with the first elevator moving up",
+			states: map[string]HRAElevState{
+				"1": state("moving", 0, "up", false, false, false, false),
+				"2": state("idle", 3, "stop", false, false, false, false),
+			},
+			hall: [][2]bool{{false, false}, {false, true}, {true, false}, {false, false}},
+			want: map[string][][2]bool{
+				"1": {{false, false}, {false, true}, {false, false}, {false, false}},
+				"2": {{false, false}, {false, false}, {true, false}, {false, false}},
+			},
+		},
+		{
+			name: "cab order ahead makes the elevator skip the order in the wrong direction",
+			states: map[string]HRAElevState{
+				"1": state("idle", 0, "stop", false, false, true, false),
+				"2": state("idle", 3, "stop", false, false, false, false),
+			},
+			hall: [][2]bool{{false, false}, {false, true}, {true, false}, {false, false}},
+			want: map[string][][2]bool{
+				"1": {{false, false}, {false, false}, {true, false}, {false, false}},
+				"2": {{false, false}, {false, true}, {false, false}, {false, false}},
+			},
+		},
+		{
+			name: "equally far, the elevator moving toward the order gets it",
+			states: map[string]HRAElevState{
+				"27": state("moving", 1, "down", false, false, false, false),
+				"20": state("doorOpen", 1, "down", false, false, false, false),
+			},
+			hall: [][2]bool{{true, false}, {false, false}, {false, false}, {false, false}},
+			want: map[string][][2]bool{
+				"27": {{true, false}, {false, false}, {false, false}, {false, false}},
+				"20": {{false, false}, {false, false}, {false, false}, {false, false}},
+			},
+		},
+		{
+			name: "two orders at one floor are split when the closest elevator has a cab order beyond",
+			states: map[string]HRAElevState{
+				"1": state("moving", 3, "down", true, false, false, false),
+				"2": state("idle", 3, "down", false, false, false, false),
+			},
+			hall: [][2]bool{{false, false}, {true, true}, {false, false}, {false, false}},
+			want: map[string][][2]bool{
+				"1": {{false, false}, {false, true}, {false, false}, {false, false}},
+				"2": {{false, false}, {true, false}, {false, false}, {false, false}},
+			},
+		},
+		{
+			name: "identical elevators, the lowest ID wins",
+			states: map[string]HRAElevState{
+				"1": state("moving", 1, "up", true, false, false, false),
+				"2": state("idle", 1, "stop", true, false, false, false),
+				"3": state("idle", 1, "stop", true, false, false, false),
+			},
+			hall: [][2]bool{{true, false}, {false, false}, {false, false}, {false, true}},
+			want: map[string][][2]bool{
+				"1": {{false, false}, {false, false}, {false, false}, {false, true}},
+				"2": {{true, false}, {false, false}, {false, false}, {false, false}},
+				"3": {{false, false}, {false, false}, {false, false}, {false, false}},
+			},
+		},
+		{
+			name: "orders in both directions at one floor go to different elevators",
+			states: map[string]HRAElevState{
+				"one": state("idle", 0, "down", false, false, false, false),
+				"two": state("idle", 3, "down", false, false, false, false),
+			},
+			hall: [][2]bool{{false, false}, {true, true}, {true, false}, {false, false}},
+			want: map[string][][2]bool{
+				"one": {{false, false}, {true, false}, {true, false}, {false, false}},
+				"two": {{false, false}, {false, true}, {false, false}, {false, false}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := OptimalHallRequests(HRAInput{HallRequests: test.hall, States: test.states}, DefaultHRAConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got  %v\nwant %v", got, test.want)
+			}
+		})
+	}
+}
+
+// Up and down orders at floors 1 and 2: a single stop at 1, a double stop at 2, then a single stop at 1 again
+func TestSingleMovesInDirection(t *testing.T) {
+	s := hraState{
+		id:     "one",
+		state:  hraLocalState{behaviour: "idle", floor: 0, direction: 0, cabRequests: make([]bool, 4)},
+		config: DefaultHRAConfig,
+	}
+	reqs := make([][2]hraReq, 4)
+	for _, floor := range []int{1, 2} {
+		reqs[floor] = [2]hraReq{{active: true}, {active: true}}
+	}
+
+	for anyUnassigned(reqs) {
+		performSingleMove(&s, reqs)
+	}
+
+	want := 4*DefaultHRAConfig.DoorOpenDuration + 3*DefaultHRAConfig.TravelDuration
+	if s.time != want {
+		t.Errorf("took %v, want %v", s.time, want)
+	}
+}
+
+// A destination order stays with the elevator it was given to, even when another one is closer,
+// and only moves when that elevator is gone from the input
+func TestDestinationStaysWithItsCar(t *testing.T) {
+	given := state("idle", 0, "stop", false, false, false, false)
+	given.DestRequests = [][]bool{make([]bool, 4), make([]bool, 4), make([]bool, 4), {false, true, false, false}}
+	input := HRAInput{
+		HallRequests: make([][2]bool, 4),
+		DestRequests: [][]bool{make([]bool, 4), make([]bool, 4), make([]bool, 4), {false, true, false, false}},
+		States: map[string]HRAElevState{
+			"1": given,
+			"2": state("idle", 3, "stop", false, false, false, false),
+		},
+	}
+
+	got := AssignDestinations(input, nil, DefaultHRAConfig)
+	if !got["1"][3][1] || got["2"][3][1] {
+		t.Errorf("got %v, want the order kept by 1", got)
+	}
+
+	delete(input.States, "1")
+	got = AssignDestinations(input, nil, DefaultHRAConfig)
+	if !got["2"][3][1] {
+		t.Errorf("got %v, want the order moved to 2", got)
+	}
+
+	input.States["1"] = state("idle", 0, "stop", false, false, false, false)
+	got = AssignDestinations(input, nil, DefaultHRAConfig)
+	if !got["2"][3][1] {
+		t.Errorf("got %v, want a new order with the closest elevator", got)
+	}
+}
@@ -0,0 +1,106 @@
+package cost
+
+import (
+	"time"
+)
+
+// Wraps another Assigner and keeps hall requests with the elevator they are already assigned to.
+// A request only moves when the new elevator serves it more than Margin faster, or when the current one disappears from the input.
+// Which elevator a request sticks to is not shared between nodes, so only use it where one coordinator assigns for everyone.
+type Hysteresis struct {
+	Inner      Assigner
+	Config     HRAConfig
+	Margin     time.Duration
+	assignedTo [][2]string
+	rejected   [][2]string
+}
+
+func NewHysteresis(inner Assigner, config HRAConfig, margin time.Duration) *Hysteresis {
+	return &Hysteresis{Inner: inner, Config: config, Margin: margin}
+}
+
+var buttonNames = [2]string{"up", "down"}
+
+func (h *Hysteresis) Assign(input HRAInput) map[string][][2]bool {
+	proposed := h.Inner.Assign(input)
+	if proposed == nil {
+		return nil
+	}
+	numFloors := len(input.HallRequests)
+	if len(h.assignedTo) != numFloors {
+		h.assignedTo = make([][2]string, numFloors)
+		h.rejected = make([][2]string, numFloors)
+	}
+
+	proposedTo := make([][2]string, numFloors)
+	for id, hallRequests := range proposed {
+		for floor := range hallRequests {
+			for button := 0; button < 2; button++ {
+				if hallRequests[floor][button] {
+					proposedTo[floor][button] = id
+				}
+			}
+		}
+	}
+
+	// Keep every request with its current elevator if that elevator is still there
+	sticky := make([][2]string, numFloors)
+	for floor := range sticky {
+		for button := 0; button < 2; button++ {
+			current := h.assignedTo[floor][button]
+			_, available := input.States[current]
+			switch {
+			case !input.HallRequests[floor][button]:
+				sticky[floor][button] = ""
+			case current != "" && available:
+				sticky[floor][button] = current
+			default:
+				if current != "" && current != proposedTo[floor][button] {
+					logger.Info("Hall order moved, its elevator is unavailable", "floor", floor, "button", buttonNames[button], "from", current, "to", proposedTo[floor][button])
+				}
+				sticky[floor][button] = proposedTo[floor][button]
+			}
+		}
+	}
+
+	next := make([][2]string, numFloors)
+	for floor := range next {
+		for button := 0; button < 2; button++ {
+			keep := sticky[floor][button]
+			change := proposedTo[floor][button]
+			next[floor][button] = keep
+			if keep == change || change == "" {
+				h.rejected[floor][button] = ""
+				continue
+			}
+			keepCost := requestCost(input, sticky, keep, floor, button, h.Config) //Both with the other requests where they stick
+			changeCost := requestCost(input, sticky, change, floor, button, h.Config)
+			if keepCost-changeCost > h.Margin {
+				logger.Info("Hall order moved", "floor", floor, "button", buttonNames[button], "from", keep, "to", change, "faster", keepCost-changeCost)
+				next[floor][button] = change
+				h.rejected[floor][button] = ""
+			} else if h.rejected[floor][button] != change {
+				logger.Debug("Hall order kept", "floor", floor, "button", buttonNames[button], "at", keep, "alternative", change, "faster", keepCost-changeCost)
+				h.rejected[floor][button] = change
+			}
+		}
+	}
+	h.assignedTo = next
+
+	output := emptyAssignment(sortedIDs(input), numFloors)
+	for floor := range next {
+		for button := 0; button < 2; button++ {
+			if id := next[floor][button]; id != "" {
+				output[id][floor][button] = true
+			}
+		}
+	}
+	return output
+}
+
+// Time for elevator id to serve the request at (floor, button) when it has the hall requests given by assignedTo
+func requestCost(input HRAInput, assignedTo [][2]string, id string, floor int, button int, config HRAConfig) time.Duration {
+	e := workload(input, assignedTo, id)
+	e.requests[floor][button] = true
+	return timeToServe(e, input.States[id].Behavior, floor, button, config.forElevator(input.States[id]))
+}
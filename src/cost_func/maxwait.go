@@ -0,0 +1,119 @@
+package cost
+
+import (
+	"time"
+)
+
+// Age-aware assignment. Starts from the full reassignment and moves hall requests between elevators
+// as long as that lowers the longest wait, counting how long each order has already waited.
+// Among assignments with the same longest wait, the one with the lowest total wait is used.
+// The hall order ages are when this node first saw each order, which the other nodes don't know,
+// so only use it where one coordinator assigns for everyone.
+type MaxWait struct {
+	Config HRAConfig
+}
+
+type waitCost struct {
+	max   time.Duration
+	total time.Duration
+}
+
+func (c waitCost) less(other waitCost) bool {
+	if c.max != other.max {
+		return c.max < other.max
+	}
+	return c.total < other.total
+}
+
+func (a *MaxWait) Assign(input HRAInput) map[string][][2]bool {
+	output, err := OptimalHallRequests(input, a.Config)
+	if err != nil {
+		logger.Error("MaxWait failed", "err", err)
+		return nil
+	}
+	ids := sortedIDs(input)
+	numFloors := len(input.HallRequests)
+
+	assignedTo := make([][2]string, numFloors)
+	for id, hallRequests := range output {
+		for floor := range hallRequests {
+			for button := 0; button < 2; button++ {
+				if hallRequests[floor][button] {
+					assignedTo[floor][button] = id
+				}
+			}
+		}
+	}
+
+	best := a.waitCost(input, assignedTo, ids)
+	for improved := true; improved; {
+		improved = false
+		for floor := range assignedTo {
+			for button := 0; button < 2; button++ {
+				current := assignedTo[floor][button]
+				if current == "" {
+					continue
+				}
+				for _, id := range ids {
+					if id == current {
+						continue
+					}
+					assignedTo[floor][button] = id
+					if cost := a.waitCost(input, assignedTo, ids); cost.less(best) {
+						best = cost
+						current = id
+						improved = true
+					}
+				}
+				assignedTo[floor][button] = current
+			}
+		}
+	}
+
+	output = emptyAssignment(ids, numFloors)
+	for floor := range assignedTo {
+		for button := 0; button < 2; button++ {
+			if id := assignedTo[floor][button]; id != "" {
+				output[id][floor][button] = true
+			}
+		}
+	}
+	return output
+}
+
+// Longest and total wait for all hall and cab requests, where the wait is the age of the order plus the time until it is served
+func (a *MaxWait) waitCost(input HRAInput, assignedTo [][2]string, ids []string) waitCost {
+	var cost waitCost
+	for _, id := range ids {
+		elevState := input.States[id]
+		config := a.Config.forElevator(elevState)
+		e := workload(input, assignedTo, id)
+		served := make([][3]bool, len(e.requests))
+		addWait := func(floor int, button int, wait time.Duration) {
+			if button == hraCab && floor < len(elevState.CabRequestAges) {
+				wait += elevState.CabRequestAges[floor]
+			} else if button != hraCab && floor < len(input.HallRequestAges) {
+				wait += input.HallRequestAges[floor][button]
+			}
+			cost.total += wait
+			if wait > cost.max {
+				cost.max = wait
+			}
+		}
+
+		idle := simulate(e, elevState.Behavior, config, func(floor int, button int, at time.Duration) bool {
+			served[floor][button] = true
+			addWait(floor, button, at)
+			return false
+		})
+
+		for floor := range e.requests { //Requests the simulation never got to are counted as served at the very end
+			for button := 0; button < 3; button++ {
+				if e.requests[floor][button] && !served[floor][button] {
+					addWait(floor, button, idle+config.DoorOpenDuration)
+				}
+			}
+		}
+	}
+	return cost
+}
@@ -2,51 +2,63 @@ package elev
 
 import (
 	"heis/src/elevio"
+	"time"
 )
 
+// Backups bring back cab orders for this long after the first other node is alive, which takes peers.JoinDelay
+// after CabInit. That is time for the first status messages, but not for a stale backup to undo a cleared order later.
+const _cabRecoveryWindow = 1 * time.Second
+
+// Next status of an order, given the status here and on another node.
+// An executed order is only set inactive once every alive node has agreed it is executed.
+func orderConsensus(local OrderStatus, remote OrderStatus, othersCleared func() bool) OrderStatus {
+	switch {
+	case local == Order_Inactive && remote == Order_Pending: // Inactive -> Pending
+		return Order_Pending
+	case local == Order_Inactive && remote == Order_Active: // Inactive ->active, should only happen after network loss
+		return Order_Active
+	case local == Order_Pending && (remote == Order_Pending || remote == Order_Active): // Order is pending, recieves either pending or active -> active
+		return Order_Active
+	case local == Order_Active && remote == Order_PendingInactive: //Active here, has been executed somewhere else -> pending ianactive
+		return Order_PendingInactive
+	case local == Order_PendingInactive && remote == Order_Pending: //Order was executed but was reordered
+		return Order_Pending
+	case local == Order_PendingInactive && (remote == Order_PendingInactive || remote == Order_Inactive) && othersCleared():
+		return Order_Inactive
+	}
+	return local
+}
+
+// True if every alive node has the order given by status as inactive or pending inactive
+func (e *Elevator) othersCleared(OtherNodes map[string]ElevatorMessage, status func(ElevatorMessage) OrderStatus) bool {
+	for id, otherNodeStatus := range OtherNodes {
+		if e.OtherNodes.Alive[id] {
+			states := status(otherNodeStatus)
+			if states != Order_Inactive && states != Order_PendingInactive { //if not in agreement, then we are not ready to set inactive.
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func (e *Elevator) HallConsensus(Node ElevatorMessage, OtherNodes map[string]ElevatorMessage) {
 	for floor := 0; floor < elevio.NumFloors; floor++ {
 		for button := 0; button < 2; button++ {
-			switch {
-			case (e.Orders.ListHall[floor][button] == Order_Inactive) && (Node.OrderListHall[floor][button] == Order_Pending): // Inactive -> Pending
-				e.Orders.ListHall[floor][button] = Order_Pending
-
-			case (e.Orders.ListHall[floor][button] == Order_Inactive) && Node.OrderListHall[floor][button] == Order_Active: // Inactive ->active, should only happen after network loss
-				e.Orders.ListHall[floor][button] = Order_Active
-				e.SetElevButtonLamp(elevio.ButtonType(button), floor, true)
+			old := e.Orders.ListHall[floor][button]
+			next := orderConsensus(old, Node.OrderListHall[floor][button], func() bool {
+				return e.othersCleared(OtherNodes, func(msg ElevatorMessage) OrderStatus { return msg.OrderListHall[floor][button] })
+			})
+			e.Orders.ListHall[floor][button] = next
 
-			case (e.Orders.ListHall[floor][button] == Order_Pending) && ((Node.OrderListHall[floor][button] == Order_Pending) || (Node.OrderListHall[floor][button] == Order_Active)): // Order is pending, recieves either pending or active -> active
-				e.Orders.ListHall[floor][button] = Order_Active
+			if next == Order_Active && old != Order_Active {
 				e.SetElevButtonLamp(elevio.ButtonType(button), floor, true)
-
-			case (e.Orders.ListHall[floor][button] == Order_Active) && (Node.OrderListHall[floor][button] == Order_PendingInactive): //Active here, has been executed somewhere else -> pending ianactive
-				e.Orders.ListHall[floor][button] = Order_PendingInactive
-
-			case (e.Orders.ListHall[floor][button] == Order_PendingInactive) && Node.OrderListHall[floor][button] == Order_Pending: //Order was executed but was reordered
-				e.Orders.ListHall[floor][button] = Order_Pending
-
-			case (e.Orders.ListHall[floor][button] == Order_PendingInactive || e.Orders.ListHall[floor][button] == Order_Inactive) && (Node.OrderListHall[floor][button] == Order_PendingInactive || Node.OrderListHall[floor][button] == Order_Inactive): // inactive/pendingInactive here or on external Node
-				if e.Orders.ListHall[floor][button] == Order_PendingInactive {
-					ClearConsensus := true
-					for id, otherNodeStatus := range OtherNodes {
-						if e.OtherNodes.Alive[id] {
-							states := otherNodeStatus.OrderListHall[floor][button]
-							if states != Order_Inactive && states != Order_PendingInactive { //if not in agreement, then we are not ready to set inactive.
-								ClearConsensus = false
-								break
-							}
-						}
-					}
-					if ClearConsensus {
-						e.Orders.ListHall[floor][button] = Order_Inactive
-						e.SetElevButtonLamp(elevio.ButtonType(button), floor, false)
-					}
-				}
-			default:
-				continue
+			} else if next == Order_Inactive && old == Order_PendingInactive {
+				e.SetElevButtonLamp(elevio.ButtonType(button), floor, false)
 			}
 		}
 	}
+	e.DestinationConsensus(Node, OtherNodes)
 
 	CabBackup, exists := Node.CabBackupMap[e.OtherNodes.ID]
 	if !exists {
@@ -57,7 +69,7 @@ func (e *Elevator) HallConsensus(Node ElevatorMessage, OtherNodes map[string]Ele
 		case (e.Orders.ListCab[floor] == Order_Pending) && CabBackup[floor] == Order_Active:
 			e.Orders.ListCab[floor] = Order_Active
 			e.SetElevButtonLamp(elevio.ButtonType(2), floor, true)
-		case (e.Orders.ListCab[floor] == Order_Inactive) && CabBackup[floor] == Order_Active && e.OtherNodes.MessageCount < 100: //If under 100 messages sent, recovery of caborders is active.
+		case (e.Orders.ListCab[floor] == Order_Inactive) && CabBackup[floor] == Order_Active && e.recoveringCabOrders():
 			if e.State.Floor == floor && e.State.DoorOpen { //Handles edge case to avoid double opening of door in floor 0 after reboot.
 				continue
 			}
@@ -69,6 +81,17 @@ func (e *Elevator) HallConsensus(Node ElevatorMessage, OtherNodes map[string]Ele
 	}
 }
 
+// True for _cabRecoveryWindow after the first other node is alive
+func (e *Elevator) recoveringCabOrders() bool {
+	return !e.OtherNodes.FirstAlive.IsZero() && time.Since(e.OtherNodes.FirstAlive) < _cabRecoveryWindow
+}
+
+// Keeps the cab backup of a restarted node for _cabRecoveryWindow, so its first empty cab list does not
+// clear the backup before the node has taken it back
+func (e *Elevator) NodeRestarted(ID string) {
+	e.OtherNodes.Restarted[ID] = time.Now()
+}
+
 func (e *Elevator) CabBackupFunc(Node ElevatorMessage) {
 	cabBackup, exists := e.Orders.CabBackupList[Node.SenderID]
 	if !exists {
@@ -85,7 +108,10 @@ func (e *Elevator) CabBackupFunc(Node ElevatorMessage) {
 		case (currentBackupStates == Order_Pending) && (incomingCabStates == Order_Pending || incomingCabStates == Order_Active):
 			cabBackup[floor] = Order_Active
 
-		case (currentBackupStates == Order_Active) && (incomingCabStates == Order_Inactive):
+		case (currentBackupStates == Order_Inactive) && (incomingCabStates == Order_Active): //Recovered by the node after a restart
+			cabBackup[floor] = Order_Active
+
+		case (currentBackupStates == Order_Active) && (incomingCabStates == Order_Inactive) && time.Since(e.OtherNodes.Restarted[Node.SenderID]) >= _cabRecoveryWindow:
 			cabBackup[floor] = Order_Inactive
 
 		default:
@@ -95,3 +121,23 @@ func (e *Elevator) CabBackupFunc(Node ElevatorMessage) {
 	}
 	e.Orders.CabBackupList[Node.SenderID] = cabBackup // Writes new status to map
 }
+
+// Takes back the cab orders another node kept for this one. Returns true if any were recovered.
+func (e *Elevator) RecoverCabOrders(msg CabRecoveryMessage) bool {
+	var recovered []int
+	for floor := 0; floor < elevio.NumFloors && floor < len(msg.Cab); floor++ {
+		if e.Orders.ListCab[floor] != Order_Inactive || msg.Cab[floor] != Order_Active {
+			continue
+		}
+		if e.State.Floor == floor && e.State.DoorOpen {
+			continue
+		}
+		e.Orders.ListCab[floor] = Order_Active
+		e.SetElevButtonLamp(elevio.ButtonType(2), floor, true)
+		recovered = append(recovered, floor)
+	}
+	if len(recovered) > 0 {
+		e.log().Info("Recovered cab orders", "from", msg.SenderID, "floors", recovered)
+	}
+	return len(recovered) > 0
+}
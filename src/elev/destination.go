@@ -0,0 +1,117 @@
+package elev
+
+import (
+	"bufio"
+	"fmt"
+	"heis/src/elevio"
+	"io"
+)
+
+// A passenger at Origin who wants to go to Destination. The letter of the car that will pick them up is sent on Reply,
+// and sent again if the order moves to another car. Reply is closed once they are picked up.
+type DestinationRequest struct {
+	Origin      int
+	Destination int
+	Reply       chan<- string
+}
+
+func (e *Elevator) AddDestinationOrder(origin int, destination int) {
+	if e.RunningAlone() {
+		e.Orders.ListDest[origin][destination] = Order_Active
+		return
+	}
+	if e.Orders.ListDest[origin][destination] != Order_Active {
+		e.Orders.ListDest[origin][destination] = Order_Pending
+	}
+}
+
+func (e *Elevator) DestinationConsensus(Node ElevatorMessage, OtherNodes map[string]ElevatorMessage) {
+	if len(Node.OrderListDest) != len(e.Orders.ListDest) { //Sent by a node without destination dispatch
+		return
+	}
+	for origin := range e.Orders.ListDest {
+		for destination := range e.Orders.ListDest[origin] {
+			e.Orders.ListDest[origin][destination] = orderConsensus(e.Orders.ListDest[origin][destination], Node.OrderListDest[origin][destination], func() bool {
+				return e.othersCleared(OtherNodes, func(msg ElevatorMessage) OrderStatus {
+					if len(msg.OrderListDest) != len(e.Orders.ListDest) {
+						return Order_Inactive
+					}
+					return msg.OrderListDest[origin][destination]
+				})
+			})
+		}
+	}
+}
+
+// Copies in a new assignment. A missing hall assignment keeps the old one, a missing destination assignment clears it.
+func (e *Elevator) SetAssignment(assigned [][2]bool, assignedDest [][]bool) {
+	if assigned != nil {
+		e.Orders.Assigned = append([][2]bool(nil), assigned...)
+	}
+	for origin := range e.Orders.AssignedDest {
+		for destination := range e.Orders.AssignedDest[origin] {
+			e.Orders.AssignedDest[origin][destination] = origin < len(assignedDest) && destination < len(assignedDest[origin]) && assignedDest[origin][destination]
+		}
+	}
+	e.AssignDestinationStops()
+}
+
+// Hall requests at the origin of every destination order assigned to this elevator, so the fsm stops there
+func (e *Elevator) AssignDestinationStops() {
+	for origin := range e.Orders.AssignedDest {
+		for destination, assigned := range e.Orders.AssignedDest[origin] {
+			if !assigned {
+				continue
+			}
+			if destination > origin {
+				e.Orders.Assigned[origin][elevio.BT_HallUp] = true
+			} else {
+				e.Orders.Assigned[origin][elevio.BT_HallDown] = true
+			}
+		}
+	}
+}
+
+// Everyone waiting for this elevator at the current floor gets on, and their destinations become cab orders
+func (e *Elevator) PickUpDestinations() {
+	if len(e.Orders.AssignedDest) <= e.State.Floor {
+		return
+	}
+	for destination, assigned := range e.Orders.AssignedDest[e.State.Floor] {
+		if !assigned || e.Orders.ListDest[e.State.Floor][destination] != Order_Active {
+			continue
+		}
+		e.Orders.ListDest[e.State.Floor][destination] = Order_PendingInactive
+		e.Orders.AssignedDest[e.State.Floor][destination] = false
+		e.UpdateElevatorOrder(elevio.ButtonEvent{Floor: destination, Button: elevio.BT_Cab})
+	}
+}
+
+// Reads "<origin> <destination>" lines from a destination panel, here a terminal, and prints which car to take
+func DestinationPanel(input io.Reader, requests chan<- DestinationRequest) {
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		var origin, destination int
+		if _, err := fmt.Sscan(scanner.Text(), &origin, &destination); err != nil {
+			logger.Warn("Destination panel expected \"<origin> <destination>\"", "input", scanner.Text())
+			continue
+		}
+		if origin < 0 || origin >= elevio.NumFloors || destination < 0 || destination >= elevio.NumFloors || origin == destination {
+			logger.Warn("Destination panel got an invalid trip", "origin", origin, "destination", destination)
+			continue
+		}
+		reply := make(chan string, 1)
+		requests <- DestinationRequest{Origin: origin, Destination: destination, Reply: reply}
+		go func() {
+			previous := ""
+			for letter := range reply {
+				if previous == "" {
+					fmt.Printf("Floor %d to %d: take elevator %s\n", origin, destination, letter)
+				} else {
+					fmt.Printf("Floor %d to %d: elevator %s is unavailable, take elevator %s\n", origin, destination, previous, letter)
+				}
+				previous = letter
+			}
+		}()
+	}
+}
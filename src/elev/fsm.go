@@ -1,16 +1,20 @@
 package elev
 
 import (
-	"fmt"
 	"heis/src/elevio"
 	"time"
 )
 
 func (e *Elevator) StoppFloor() {
 	e.SetElevMotorDirection(0)
+	if !e.State.DoorOpen {
+		e.Timing.doorOpened = time.Now()
+		e.Timing.doorObstructed = false
+	}
 	e.State.DoorOpen = true
 	e.SetElevDoorOpenLamp(true)
 	e.ClearOrderFloor()
+	e.PickUpDestinations()
 
 }
 
@@ -78,7 +82,7 @@ func (e *Elevator) ExecuteOrder() {
 	}
 	nextDir := e.ChooseDirection()
 	if nextDir != e.State.Direction {
-		fmt.Printf("Going %s \n", dirMap[int(nextDir)])
+		e.log().Info("Going", "to", dirMap[int(nextDir)])
 	}
 	e.SetElevMotorDirection(nextDir)
 }
@@ -100,17 +104,19 @@ func (e *Elevator) GoingWrongway(event *elevio.ButtonEvent) {
 
 func (e *Elevator) DoorTimeHandler(doorTimer *time.Timer, time time.Duration) {
 	if e.State.Obstructed {
-		fmt.Printf("Cab obstructed, keeping door open \n")
+		e.log().Info("Cab obstructed, keeping door open")
+		e.Timing.doorObstructed = true
 		doorTimer.Reset(time)
 	} else if e.State.AnnouncementPending {
 		e.State.AnnouncementPending = false
 		e.State.AnnouncedDirection = elevio.MD_Stop
-		fmt.Printf("Changing Directions \n")
+		e.log().Info("Changing direction")
 		doorTimer.Reset(time)
 	} else {
-		fmt.Printf("Door closing \n")
+		e.log().Info("Door closing")
 		e.State.DoorOpen = false
 		e.SetElevDoorOpenLamp(false)
+		e.measureDoor()
 
 		if e.State.Stuck {
 			e.State.Stuck = false //Go back online after obstruction is resolved.
@@ -124,7 +130,7 @@ func (e *Elevator) DoorTimeHandler(doorTimer *time.Timer, time time.Duration) {
 }
 
 func (e *Elevator) StateChanged(msg ElevatorMessage, otherNodes map[string]ElevatorMessage) bool {
-	return (!HallOrdersEqual(msg.OrderListHall, otherNodes[msg.SenderID].OrderListHall)) || !CabOrdersEqual(msg.OrderListCab, otherNodes[msg.SenderID].OrderListCab)
+	return (!HallOrdersEqual(msg.OrderListHall, otherNodes[msg.SenderID].OrderListHall)) || !CabOrdersEqual(msg.OrderListCab, otherNodes[msg.SenderID].OrderListCab) || !HallOrdersEqual(msg.OrderListDest, otherNodes[msg.SenderID].OrderListDest)
 }
 
 func (e *Elevator) StuckHandler(lastFloorChangeTime *time.Time) {
@@ -133,7 +139,7 @@ func (e *Elevator) StuckHandler(lastFloorChangeTime *time.Time) {
 	}
 	movingButStuck := (e.State.Direction != elevio.MD_Stop) && (time.Since(*lastFloorChangeTime) > 3500*time.Millisecond)
 	if movingButStuck && !e.State.Stuck {
-		fmt.Printf("Motor is stuck\n")
+		e.log().Warn("Motor is stuck")
 		e.State.Stuck = true
 		e.SetElevMotorDirection(elevio.MD_Stop)
 	}
@@ -145,7 +151,10 @@ func (e *Elevator) StuckHandler(lastFloorChangeTime *time.Time) {
 
 func (e *Elevator) ObstructionHandler(obstruction bool, doorObstructedTimer *time.Timer, obstructionLimit time.Duration, doorTimer *time.Timer, doorTimeOpen time.Duration) {
 	e.State.Obstructed = obstruction
-	fmt.Printf("Obstruction: %v \n", e.State.Obstructed)
+	if obstruction && e.State.DoorOpen {
+		e.Timing.doorObstructed = true
+	}
+	e.log().Info("Obstruction", "obstructed", e.State.Obstructed)
 	doorObstructedTimer.Reset(obstructionLimit)
 	if !obstruction && e.State.DoorOpen {
 		doorTimer.Reset(doorTimeOpen)
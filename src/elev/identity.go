@@ -0,0 +1,35 @@
+package elev
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"strings"
+)
+
+// Reads the node ID saved in path, or makes up a new one starting with prefix and saves it there,
+// so the node keeps its ID across restarts
+func LoadOrCreateID(path string, prefix string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id, nil
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	id := fmt.Sprintf("%s-%08x", prefix, rand.Uint32())
+	if err := os.WriteFile(path, []byte(id+"\n"), 0644); err != nil {
+		return "", err
+	}
+	logger.Info("Generated node ID", "id", id, "path", path)
+	return id, nil
+}
+
+// True if msg carries our ID but was sent by another running node
+func (e *Elevator) DuplicateID(msg ElevatorMessage) bool {
+	return msg.SenderID == e.OtherNodes.ID && msg.Instance != e.OtherNodes.Instance
+}
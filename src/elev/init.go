@@ -9,6 +9,9 @@ const _pollRate = 20 * time.Millisecond
 
 func (e *Elevator) UpdateFloor(Floor int) {
 	if Floor != -1 {
+		if Floor != e.State.Floor && e.State.Direction != elevio.MD_Stop {
+			e.measureTravel()
+		}
 		e.State.Floor = Floor
 	}
 }
@@ -18,16 +21,28 @@ func (e *Elevator) UpdateDirection(Direction elevio.MotorDirection) {
 	e.State.Direction = Direction
 }
 
-func (e *Elevator) CabInit(ID string, numFloors int) {
+func (e *Elevator) InitOrders(numFloors int) {
 	e.Orders.ListHall = make([][]OrderStatus, numFloors)
 	e.Orders.Assigned = make([][2]bool, numFloors)
 	for floor := range e.Orders.ListHall {
 		e.Orders.ListHall[floor] = make([]OrderStatus, 2) //Fills OrderStatus for every floor
 	}
 	e.Orders.ListCab = make([]OrderStatus, numFloors)
+	e.Orders.TimeHall = make([][2]time.Time, numFloors)
+	e.Orders.TimeCab = make([]time.Time, numFloors)
 	e.Orders.CabBackupList = make(map[string][]OrderStatus)
+	e.Orders.ListDest = make([][]OrderStatus, numFloors)
+	e.Orders.AssignedDest = make([][]bool, numFloors)
+	for origin := range e.Orders.ListDest {
+		e.Orders.ListDest[origin] = make([]OrderStatus, numFloors)
+		e.Orders.AssignedDest[origin] = make([]bool, numFloors)
+	}
+}
+
+func (e *Elevator) CabInit(ID string, numFloors int) {
+	e.InitOrders(numFloors)
 
-	for elevio.GetFloor() != 0 {
+	for e.driver().GetFloor() != 0 {
 		e.SetElevMotorDirection(elevio.MD_Down)
 		time.Sleep(_pollRate)
 	}
@@ -41,6 +56,8 @@ func (e *Elevator) CabInit(ID string, numFloors int) {
 	e.OtherNodes.Alive = make(map[string]bool)
 	e.OtherNodes.ID = ID
 	e.OtherNodes.MessageCount = 0
+	e.OtherNodes.FirstAlive = time.Time{}
+	e.OtherNodes.Restarted = make(map[string]time.Time)
 	e.State.Obstructed = false
 	e.State.Stuck = false
 }
@@ -57,16 +74,26 @@ func (e *Elevator) UpdateBehaviour() {
 }
 
 func (e *Elevator) SetElevMotorDirection(dir elevio.MotorDirection) {
-	elevio.SetMotorDirection(dir)
+	if dir != elevio.MD_Stop && e.State.Direction == elevio.MD_Stop {
+		e.Timing.departed = time.Now()
+	}
+	e.driver().SetMotorDirection(dir)
 	e.UpdateDirection(dir)
 	e.UpdateBehaviour()
 }
 
 func (e *Elevator) SetElevButtonLamp(button elevio.ButtonType, floor int, value bool) {
-	elevio.SetButtonLamp(button, floor, value)
+	e.driver().SetButtonLamp(button, floor, value)
 }
 
 func (e *Elevator) SetElevDoorOpenLamp(value bool) {
-	elevio.SetDoorOpenLamp(value)
+	e.driver().SetDoorOpenLamp(value)
 	e.UpdateBehaviour()
 }
+
+func (e *Elevator) driver() *elevio.Driver {
+	if e.Driver != nil {
+		return e.Driver
+	}
+	return elevio.Default()
+}
@@ -0,0 +1,13 @@
+package elev
+
+import (
+	"heis/src/logging"
+	"log/slog"
+)
+
+var logger = logging.For(logging.Elev)
+
+// Logger that adds where the elevator is and what it is doing
+func (e *Elevator) log() *slog.Logger {
+	return logger.With("floor", e.State.Floor, "behaviour", e.State.Behaviour, "direction", dirMap[int(e.State.Direction)])
+}
@@ -2,6 +2,7 @@ package elev
 
 import (
 	"heis/src/elevio"
+	"time"
 )
 
 func (e *Elevator) UpdateElevatorOrder(event elevio.ButtonEvent) {
@@ -121,6 +122,46 @@ func (e *Elevator) UpdateHallLights() {
 	}
 }
 
+func orderPlaced(status OrderStatus) bool {
+	return status == Order_Pending || status == Order_Active
+}
+
+// Notes when orders were placed, and forgets the time once they are served
+func (e *Elevator) UpdateOrderTimes() {
+	now := time.Now()
+	for floor := range e.Orders.ListHall {
+		for button := 0; button < 2; button++ {
+			if !orderPlaced(e.Orders.ListHall[floor][button]) {
+				e.Orders.TimeHall[floor][button] = time.Time{}
+			} else if e.Orders.TimeHall[floor][button].IsZero() {
+				e.Orders.TimeHall[floor][button] = now
+			}
+		}
+	}
+	for floor := range e.Orders.ListCab {
+		if !orderPlaced(e.Orders.ListCab[floor]) {
+			e.Orders.TimeCab[floor] = time.Time{}
+		} else if e.Orders.TimeCab[floor].IsZero() {
+			e.Orders.TimeCab[floor] = now
+		}
+	}
+}
+
+func OrderAge(placed time.Time) time.Duration {
+	if placed.IsZero() {
+		return 0
+	}
+	return time.Since(placed)
+}
+
+func (e *Elevator) CabOrderAges() []int {
+	ages := make([]int, len(e.Orders.TimeCab))
+	for floor, placed := range e.Orders.TimeCab {
+		ages[floor] = int(OrderAge(placed).Milliseconds())
+	}
+	return ages
+}
+
 func HallOrdersEqual(list1 [][]OrderStatus, list2 [][]OrderStatus) bool {
 	if len(list1) != len(list2) {
 		return false
@@ -0,0 +1,43 @@
+package elev
+
+import (
+	"time"
+)
+
+const (
+	_timingSmoothing = 4                //Each new sample moves the estimate a quarter of the way
+	_maxTimingSample = 20 * time.Second //Longer samples are a stop or an obstruction, not normal operation
+)
+
+// Measured floor-to-floor travel time and door cycle of this elevator, zero until the first sample
+type Timing struct {
+	TravelDuration   time.Duration
+	DoorOpenDuration time.Duration
+	departed         time.Time //When the elevator started moving or last passed a floor
+	doorOpened       time.Time
+	doorObstructed   bool
+}
+
+func updateEstimate(estimate time.Duration, sample time.Duration) time.Duration {
+	if sample <= 0 || sample > _maxTimingSample {
+		return estimate
+	}
+	if estimate == 0 {
+		return sample
+	}
+	return estimate + (sample-estimate)/_timingSmoothing
+}
+
+func (e *Elevator) measureTravel() {
+	if !e.Timing.departed.IsZero() {
+		e.Timing.TravelDuration = updateEstimate(e.Timing.TravelDuration, time.Since(e.Timing.departed))
+	}
+	e.Timing.departed = time.Now()
+}
+
+func (e *Elevator) measureDoor() {
+	if !e.Timing.doorOpened.IsZero() && !e.Timing.doorObstructed {
+		e.Timing.DoorOpenDuration = updateEstimate(e.Timing.DoorOpenDuration, time.Since(e.Timing.doorOpened))
+	}
+	e.Timing.doorOpened = time.Time{}
+}
@@ -1,6 +1,20 @@
 package elev
 
-func (e *Elevator) SendStatus(address string, networkStatusOut chan<- ElevatorMessage) {
+import (
+	"reflect"
+	"time"
+)
+
+// How often the status is sent when nothing changes, so nodes that missed a change still converge
+var SnapshotInterval = 200 * time.Millisecond
+
+const (
+	statusRepeats        = 3 //Times a changed status is sent again, in case the first one is lost
+	statusRepeatInterval = 10 * time.Millisecond
+)
+
+// The status of this elevator, with copies of the order lists so later changes don't show up in it
+func (e *Elevator) StatusMessage(address string) ElevatorMessage {
 	cabBackUpCopy := make(map[string][]OrderStatus)
 
 	for nodeID, cabOrders := range e.Orders.CabBackupList {
@@ -9,17 +23,110 @@ func (e *Elevator) SendStatus(address string, networkStatusOut chan<- ElevatorMe
 		cabBackUpCopy[nodeID] = cabOrdersCopy
 	}
 
-	message := ElevatorMessage{
+	return ElevatorMessage{
 		SenderID:      address,
+		Bank:          e.OtherNodes.Bank,
+		Instance:      e.OtherNodes.Instance,
 		CurrentFloor:  e.State.Floor,
 		Direction:     int(e.State.Direction),
-		OrderListHall: e.Orders.ListHall,
-		OrderListCab:  e.Orders.ListCab,
+		OrderListHall: copyOrderMatrix(e.Orders.ListHall),
+		OrderListCab:  append([]OrderStatus(nil), e.Orders.ListCab...),
+		OrderAgeCab:   e.CabOrderAges(),
+		OrderListDest: copyOrderMatrix(e.Orders.ListDest),
+		TravelTime:    int(e.Timing.TravelDuration.Milliseconds()),
+		DoorOpenTime:  int(e.Timing.DoorOpenDuration.Milliseconds()),
 		CabBackupMap:  cabBackUpCopy,
 		MessageID:     e.OtherNodes.MessageCount,
 		DoorOpen:      e.State.DoorOpen,
+		AssignedDest:  copyBoolMatrix(e.Orders.AssignedDest),
 		Behaviour:     e.State.Behaviour,
+		CarLetter:     e.OtherNodes.CarLetter,
+	}
+}
+
+func (e *Elevator) SendStatus(address string, networkStatusOut chan<- ElevatorMessage) {
+	networkStatusOut <- e.StatusMessage(address)
+	e.OtherNodes.MessageCount++
+}
+
+// Sends the status right away when it changes, repeats it a few times, and otherwise only sends a snapshot every SnapshotInterval.
+// Repeats keep their MessageID, so receivers that already have the message skip them.
+type StatusSender struct {
+	last     ElevatorMessage
+	repeats  int
+	lastSent time.Time
+}
+
+// Call after anything may have changed, and on a ticker that is at least as fast as statusRepeatInterval
+func (s *StatusSender) Send(e *Elevator, address string, send func(ElevatorMessage)) {
+	status := e.StatusMessage(address)
+	switch {
+	case !statusEqual(status, s.last):
+		s.repeats = statusRepeats
+	case s.repeats > 0 && time.Since(s.lastSent) >= statusRepeatInterval:
+		s.repeats--
+		send(s.last)
+		s.lastSent = time.Now()
+		return
+	case time.Since(s.lastSent) >= SnapshotInterval:
+	default:
+		return
 	}
-	networkStatusOut <- message
+	send(status)
 	e.OtherNodes.MessageCount++
+	s.last = status
+	s.lastSent = time.Now()
+}
+
+// Everything but the message ID and the order ages, which change all the time
+func statusEqual(a ElevatorMessage, b ElevatorMessage) bool {
+	a.MessageID, b.MessageID = 0, 0
+	a.OrderAgeCab, b.OrderAgeCab = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+func copyOrderMatrix(orders [][]OrderStatus) [][]OrderStatus {
+	copied := make([][]OrderStatus, len(orders))
+	for i := range orders {
+		copied[i] = append([]OrderStatus(nil), orders[i]...)
+	}
+	return copied
+}
+
+func copyBoolMatrix(rows [][]bool) [][]bool {
+	copied := make([][]bool, len(rows))
+	for i := range rows {
+		copied[i] = append([]bool(nil), rows[i]...)
+	}
+	return copied
+}
+
+// Marks exactly the nodes in ids as alive. Nodes that are no longer alive are dropped from otherNodes.
+// Returns true if anything changed.
+func (e *Elevator) SetAlive(ids []string, otherNodes map[string]ElevatorMessage) bool {
+	changed := false
+	alive := make(map[string]bool)
+	for _, id := range ids {
+		if id == e.OtherNodes.ID {
+			continue
+		}
+		alive[id] = true
+		if !e.OtherNodes.Alive[id] {
+			e.OtherNodes.Alive[id] = true
+			if e.OtherNodes.FirstAlive.IsZero() {
+				e.OtherNodes.FirstAlive = time.Now()
+			}
+			logger.Info("Node connected", "peer", id)
+			changed = true
+		}
+	}
+	for id, wasAlive := range e.OtherNodes.Alive {
+		if wasAlive && !alive[id] {
+			e.OtherNodes.Alive[id] = false
+			logger.Warn("Node lost", "peer", id)
+			delete(otherNodes, id)
+			changed = true
+		}
+	}
+	return changed
 }
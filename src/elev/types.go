@@ -2,6 +2,7 @@ package elev
 
 import (
 	"heis/src/elevio"
+	"time"
 )
 
 type Orders struct {
@@ -9,6 +10,10 @@ type Orders struct {
 	ListCab       []OrderStatus
 	CabBackupList map[string][]OrderStatus
 	Assigned      [][2]bool
+	TimeHall      [][2]time.Time //When each order was placed, zero if there is no order
+	TimeCab       []time.Time
+	ListDest      [][]OrderStatus //Destination orders, indexed by origin and destination floor
+	AssignedDest  [][]bool
 }
 
 type State struct {
@@ -26,17 +31,26 @@ type State struct {
 type OtherNodes struct {
 	Alive        map[string]bool
 	ID           string
+	Bank         string //Only nodes in the same bank work together
+	Instance     uint32 //Random for every run, tells a restarted node or a duplicate ID apart
+	CarLetter    string //What passengers are told to take, stays with the car whichever nodes are alive
 	MessageCount int
+	FirstAlive   time.Time            //When another node was first alive after the last CabInit, zero until then
+	Restarted    map[string]time.Time //When a node was last heard with a new instance
 }
 
 type Elevator struct {
 	Orders     Orders
 	State      State
 	OtherNodes OtherNodes
+	Timing     Timing
+	Driver     *elevio.Driver //Nil for the one elevio.Init opened
 }
 
 type ElevatorMessage struct {
 	SenderID     string
+	Bank         string
+	Instance     uint32
 	CurrentFloor int
 	Direction    int
 	DoorOpen     bool
@@ -44,8 +58,35 @@ type ElevatorMessage struct {
 
 	OrderListHall [][]OrderStatus
 	OrderListCab  []OrderStatus
+	OrderAgeCab   []int //Milliseconds since each cab order was placed
+	OrderListDest [][]OrderStatus
+	TravelTime    int //Measured milliseconds between floors, 0 if not measured yet
+	DoorOpenTime  int //Measured milliseconds the door stays open, 0 if not measured yet
 	CabBackupMap  map[string][]OrderStatus
 	MessageID     int
+	CarLetter     string
+	AssignedDest  [][]bool //Destination orders this car has been given, they stay with it while it is available
+}
+
+// Hall and destination assignment for every elevator, computed by the coordinator
+type AssignmentMessage struct {
+	SenderID     string
+	Bank         string
+	Instance     uint32 //Of the coordinator, epochs start over when it restarts
+	Epoch        int
+	Assigned     map[string][][2]bool
+	AssignedDest map[string][][]bool `json:",omitempty"`
+}
+
+// Cab orders a node kept as backup for another node, sent to it reliably when it restarts
+type CabRecoveryMessage struct {
+	SenderID string
+	To       string
+	Cab      []OrderStatus
+}
+
+func (msg CabRecoveryMessage) Recipient() string {
+	return msg.To
 }
 
 type OrderStatus int
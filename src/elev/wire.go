@@ -0,0 +1,312 @@
+package elev
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+)
+
+// Compact binary form of ElevatorMessage, used by bcast instead of JSON when bcast.BinaryEncoding is set.
+//
+//	version byte | fields as varints, strings and 2-bit packed order lists | fields added later | CRC-32 of everything before it
+//
+// New fields go at the end. Decoders read the fields they know and skip whatever follows, and fields an older
+// sender leaves out decode as zero, so adding a field only needs a bcast.ProtocolMinor bump.
+// Change wireVersion only when fields already in the layout change or move, nodes drop messages with a
+// version they don't know. Older nodes can't read that layout, so bump bcast.ProtocolMajor along with it.
+
+const wireVersion = 3
+
+var behaviourCodes = []string{"", "idle", "moving", "doorOpen"}
+
+// Order_PendingInactive is 4, so the statuses are mapped to 0-3 to fit in two bits
+var statusCodes = map[OrderStatus]byte{
+	Order_Inactive:        0,
+	Order_Pending:         1,
+	Order_Active:          2,
+	Order_PendingInactive: 3,
+}
+
+var codeStatuses = [4]OrderStatus{Order_Inactive, Order_Pending, Order_Active, Order_PendingInactive}
+
+func (msg ElevatorMessage) MarshalBinary() ([]byte, error) {
+	behaviour := -1
+	for code, name := range behaviourCodes {
+		if name == msg.Behaviour {
+			behaviour = code
+		}
+	}
+	if behaviour < 0 {
+		return nil, fmt.Errorf("unknown behaviour %q", msg.Behaviour)
+	}
+
+	buf := []byte{wireVersion}
+	buf = appendString(buf, msg.SenderID)
+	buf = appendString(buf, msg.Bank)
+	buf = binary.BigEndian.AppendUint32(buf, msg.Instance)
+	buf = binary.AppendVarint(buf, int64(msg.MessageID))
+	buf = binary.AppendVarint(buf, int64(msg.CurrentFloor))
+	buf = binary.AppendVarint(buf, int64(msg.Direction))
+	flags := byte(behaviour)
+	if msg.DoorOpen {
+		flags |= 1 << 2
+	}
+	buf = append(buf, flags)
+
+	var err error
+	if buf, err = appendStatusMatrix(buf, msg.OrderListHall); err != nil {
+		return nil, err
+	}
+	if buf, err = appendStatuses(buf, msg.OrderListCab); err != nil {
+		return nil, err
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(msg.OrderAgeCab)))
+	for _, age := range msg.OrderAgeCab {
+		buf = binary.AppendVarint(buf, int64(age))
+	}
+	if buf, err = appendStatusMatrix(buf, msg.OrderListDest); err != nil {
+		return nil, err
+	}
+	buf = binary.AppendVarint(buf, int64(msg.TravelTime))
+	buf = binary.AppendVarint(buf, int64(msg.DoorOpenTime))
+
+	ids := make([]string, 0, len(msg.CabBackupMap))
+	for id := range msg.CabBackupMap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	buf = binary.AppendUvarint(buf, uint64(len(ids)))
+	for _, id := range ids {
+		buf = appendString(buf, id)
+		if buf, err = appendStatuses(buf, msg.CabBackupMap[id]); err != nil {
+			return nil, err
+		}
+	}
+	buf = appendString(buf, msg.CarLetter)
+	buf = binary.AppendUvarint(buf, uint64(len(msg.AssignedDest)))
+	for _, row := range msg.AssignedDest {
+		buf = appendBools(buf, row)
+	}
+
+	return binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf)), nil
+}
+
+func (msg *ElevatorMessage) UnmarshalBinary(data []byte) error {
+	if len(data) < 5 {
+		return errors.New("message too short")
+	}
+	body, checksum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != checksum {
+		return errors.New("checksum mismatch")
+	}
+	if body[0] != wireVersion {
+		return fmt.Errorf("unsupported wire version %d", body[0])
+	}
+
+	r := &wireReader{buf: body[1:]}
+	decoded := ElevatorMessage{}
+	decoded.SenderID = r.string()
+	decoded.Bank = r.string()
+	decoded.Instance = r.uint32()
+	decoded.MessageID = int(r.varint())
+	decoded.CurrentFloor = int(r.varint())
+	decoded.Direction = int(r.varint())
+	flags := r.byte()
+	if int(flags&3) < len(behaviourCodes) {
+		decoded.Behaviour = behaviourCodes[flags&3]
+	}
+	decoded.DoorOpen = flags&(1<<2) != 0
+	decoded.OrderListHall = r.statusMatrix()
+	decoded.OrderListCab = r.statuses()
+	if n := r.length(1); n > 0 {
+		decoded.OrderAgeCab = make([]int, n)
+		for i := range decoded.OrderAgeCab {
+			decoded.OrderAgeCab[i] = int(r.varint())
+		}
+	}
+	decoded.OrderListDest = r.statusMatrix()
+	decoded.TravelTime = int(r.varint())
+	decoded.DoorOpenTime = int(r.varint())
+	decoded.CabBackupMap = make(map[string][]OrderStatus)
+	for n := r.length(1); n > 0; n-- {
+		id := r.string()
+		decoded.CabBackupMap[id] = r.statuses()
+	}
+	if r.more() { //Protocol 2.1
+		decoded.CarLetter = r.string()
+	}
+	if r.more() { //Protocol 3.1
+		decoded.AssignedDest = make([][]bool, r.length(1))
+		for i := range decoded.AssignedDest {
+			decoded.AssignedDest[i] = r.bools()
+		}
+	}
+
+	if r.err != nil {
+		return r.err
+	}
+	*msg = decoded //Anything left is fields from a newer minor version
+	return nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// Length followed by four statuses to a byte
+func appendStatuses(buf []byte, statuses []OrderStatus) ([]byte, error) {
+	buf = binary.AppendUvarint(buf, uint64(len(statuses)))
+	packed := make([]byte, (len(statuses)+3)/4)
+	for i, status := range statuses {
+		code, ok := statusCodes[status]
+		if !ok {
+			return nil, fmt.Errorf("unknown order status %d", status)
+		}
+		packed[i/4] |= code << (2 * (i % 4))
+	}
+	return append(buf, packed...), nil
+}
+
+// Length followed by eight flags to a byte
+func appendBools(buf []byte, flags []bool) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(flags)))
+	packed := make([]byte, (len(flags)+7)/8)
+	for i, flag := range flags {
+		if flag {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return append(buf, packed...)
+}
+
+func appendStatusMatrix(buf []byte, rows [][]OrderStatus) ([]byte, error) {
+	buf = binary.AppendUvarint(buf, uint64(len(rows)))
+	var err error
+	for _, row := range rows {
+		if buf, err = appendStatuses(buf, row); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// Reads the fields back in order. The first error is kept and every later read returns zero.
+type wireReader struct {
+	buf []byte
+	err error
+}
+
+func (r *wireReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.buf = nil
+}
+
+// True if there is more to read, for fields an older sender leaves out
+func (r *wireReader) more() bool {
+	return r.err == nil && len(r.buf) > 0
+}
+
+func (r *wireReader) byte() byte {
+	if len(r.buf) < 1 {
+		r.fail(errors.New("message truncated"))
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *wireReader) uint32() uint32 {
+	if len(r.buf) < 4 {
+		r.fail(errors.New("message truncated"))
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v
+}
+
+func (r *wireReader) varint() int64 {
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.fail(errors.New("bad varint"))
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+// A length is checked against what is left of the message, which stops a corrupt length from allocating gigabytes
+func (r *wireReader) length(itemsPerByte int) int {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 || v > uint64(itemsPerByte*(len(r.buf)-n)) {
+		r.fail(errors.New("bad length"))
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return int(v)
+}
+
+func (r *wireReader) string() string {
+	n := r.length(1)
+	if n > len(r.buf) {
+		r.fail(errors.New("message truncated"))
+		return ""
+	}
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
+
+func (r *wireReader) statuses() []OrderStatus {
+	n := r.length(4)
+	if n == 0 {
+		return nil
+	}
+	packedLen := (n + 3) / 4
+	if packedLen > len(r.buf) {
+		r.fail(errors.New("message truncated"))
+		return nil
+	}
+	statuses := make([]OrderStatus, n)
+	for i := range statuses {
+		statuses[i] = codeStatuses[(r.buf[i/4]>>(2*(i%4)))&3]
+	}
+	r.buf = r.buf[packedLen:]
+	return statuses
+}
+
+func (r *wireReader) statusMatrix() [][]OrderStatus {
+	n := r.length(1)
+	if n == 0 {
+		return nil
+	}
+	rows := make([][]OrderStatus, n)
+	for i := range rows {
+		rows[i] = r.statuses()
+	}
+	return rows
+}
+
+func (r *wireReader) bools() []bool {
+	n := r.length(8)
+	if n == 0 {
+		return nil
+	}
+	packedLen := (n + 7) / 8
+	if packedLen > len(r.buf) {
+		r.fail(errors.New("message truncated"))
+		return nil
+	}
+	flags := make([]bool, n)
+	for i := range flags {
+		flags[i] = r.buf[i/8]&(1<<(i%8)) != 0
+	}
+	r.buf = r.buf[packedLen:]
+	return flags
+}
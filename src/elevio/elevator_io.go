@@ -13,8 +13,17 @@ const numButtons = 3
 var _initialized bool = false
 var NumFloors int = 4
 var topFloor int = NumFloors - 1
-var _mtx sync.Mutex
-var _conn net.Conn
+var _driver = &Driver{}
+
+// The connection to one elevator server. The package functions use the one Init opened,
+// several elevators in one process each need a Driver of their own.
+type Driver struct {
+	mtx      sync.Mutex
+	conn     net.Conn
+	detached bool
+	closed   bool
+	done     chan struct{} //Closed by Close, which stops the polling
+}
 
 func Init(addr string, numFloors int) {
 	if _initialized {
@@ -22,13 +31,52 @@ func Init(addr string, numFloors int) {
 		return
 	}
 	NumFloors = numFloors
-	_mtx = sync.Mutex{}
-	var err error
-	_conn, err = net.Dial("tcp", addr)
+	_driver = Connect(addr)
+	_initialized = true
+}
+
+// Runs the driver without an elevator server, for simulations that only need the elevator logic.
+// Outputs are ignored and every input reads as zero.
+func InitDetached(numFloors int) {
+	NumFloors = numFloors
+	_driver = &Driver{detached: true}
+	_initialized = true
+}
+
+// Connects to the elevator server at addr. Set NumFloors first, or use Init.
+func Connect(addr string) *Driver {
+	conn, err := net.Dial("tcp", addr)
 	if err != nil {
 		panic(err.Error())
 	}
-	_initialized = true
+	return &Driver{conn: conn, done: make(chan struct{})}
+}
+
+// Closes the connection and stops the polling. Inputs read as zero afterwards and outputs are ignored.
+func (d *Driver) Close() {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.closed || d.done == nil {
+		return
+	}
+	d.closed = true
+	d.conn.Close()
+	close(d.done)
+}
+
+// Waits until the next poll, false once the driver is closed
+func (d *Driver) wait() bool {
+	select {
+	case <-time.After(_pollRate):
+		return true
+	case <-d.done:
+		return false
+	}
+}
+
+// The driver Init opened
+func Default() *Driver {
+	return _driver
 }
 
 type MotorDirection int
@@ -53,34 +101,61 @@ type ButtonEvent struct {
 }
 
 func SetMotorDirection(dir MotorDirection) {
-	write([4]byte{1, byte(dir), 0, 0})
+	_driver.SetMotorDirection(dir)
+}
+
+func (d *Driver) SetMotorDirection(dir MotorDirection) {
+	d.write([4]byte{1, byte(dir), 0, 0})
 }
 
 func SetButtonLamp(button ButtonType, floor int, value bool) {
-	write([4]byte{2, byte(button), byte(floor), toByte(value)})
+	_driver.SetButtonLamp(button, floor, value)
+}
+
+func (d *Driver) SetButtonLamp(button ButtonType, floor int, value bool) {
+	d.write([4]byte{2, byte(button), byte(floor), toByte(value)})
 }
 
 func SetFloorIndicator(floor int) {
-	write([4]byte{3, byte(floor), 0, 0})
+	_driver.SetFloorIndicator(floor)
+}
+
+func (d *Driver) SetFloorIndicator(floor int) {
+	d.write([4]byte{3, byte(floor), 0, 0})
 }
 
 func SetDoorOpenLamp(value bool) {
-	write([4]byte{4, toByte(value), 0, 0})
+	_driver.SetDoorOpenLamp(value)
+}
+
+func (d *Driver) SetDoorOpenLamp(value bool) {
+	d.write([4]byte{4, toByte(value), 0, 0})
 }
 
 func SetStopLamp(value bool) {
-	write([4]byte{5, toByte(value), 0, 0})
+	_driver.SetStopLamp(value)
+}
+
+func (d *Driver) SetStopLamp(value bool) {
+	d.write([4]byte{5, toByte(value), 0, 0})
 }
 
 func PollButtons(receiver chan<- ButtonEvent) {
+	_driver.PollButtons(receiver)
+}
+
+func (d *Driver) PollButtons(receiver chan<- ButtonEvent) {
 	prevButtonState := make([][3]bool, NumFloors)
-	for {
-		time.Sleep(_pollRate)
+	for d.wait() {
 		for floor := 0; floor < NumFloors; floor++ {
 			for button := ButtonType(0); button < 3; button++ {
-				pressed := GetButton(button, floor)
+				pressed := d.GetButton(button, floor)
 				if pressed != prevButtonState[floor][button] && pressed != false {
-					receiver <- ButtonEvent{floor, ButtonType(button)}
+					select {
+					case receiver <- ButtonEvent{floor, ButtonType(button)}:
+					case <-d.done:
+						return
+					}
 				}
 				prevButtonState[floor][button] = pressed
 			}
@@ -88,12 +163,17 @@ func PollButtons(receiver chan<- ButtonEvent) {
 	}
 }
 
-func PollFloorSensor(receiver chan<- int, btnPress <-chan bool, hasActiveOrders func() bool) {
-	prevFloorState := -1
-	for {
+func PollFloorSensor(receiver chan<- int, btnPress <-chan bool, activeOrders <-chan bool) {
+	_driver.PollFloorSensor(receiver, btnPress, activeOrders)
+}
 
-		time.Sleep(_pollRate)
-		currentFloor := GetFloor()
+// Sends the floor when it changes, after a button press, and on every poll while there are active orders.
+// Whether there are is sent on activeOrders whenever it changes.
+func (d *Driver) PollFloorSensor(receiver chan<- int, btnPress <-chan bool, activeOrders <-chan bool) {
+	prevFloorState := -1
+	hasActiveOrders := false
+	for d.wait() {
+		currentFloor := d.GetFloor()
 
 		buttonPressed := false
 
@@ -103,45 +183,75 @@ func PollFloorSensor(receiver chan<- int, btnPress <-chan bool, hasActiveOrders
 		default:
 			buttonPressed = false
 		}
+		select {
+		case hasActiveOrders = <-activeOrders:
+		default:
+		}
 
-		if (currentFloor != prevFloorState && currentFloor != -1) || (currentFloor != -1 && buttonPressed) || (hasActiveOrders() && currentFloor != -1) {
-			receiver <- currentFloor
+		if (currentFloor != prevFloorState && currentFloor != -1) || (currentFloor != -1 && buttonPressed) || (hasActiveOrders && currentFloor != -1) {
+			select {
+			case receiver <- currentFloor:
+			case <-d.done:
+				return
+			}
 		}
 		prevFloorState = currentFloor
 	}
 }
 
 func PollStopButton(receiver chan<- bool) {
+	_driver.PollStopButton(receiver)
+}
+
+func (d *Driver) PollStopButton(receiver chan<- bool) {
 	prevStopState := false
-	for {
-		time.Sleep(_pollRate)
-		stopPressed := GetStop()
+	for d.wait() {
+		stopPressed := d.GetStop()
 		if stopPressed != prevStopState {
-			receiver <- stopPressed
+			select {
+			case receiver <- stopPressed:
+			case <-d.done:
+				return
+			}
 		}
 		prevStopState = stopPressed
 	}
 }
 
 func PollObstructionSwitch(receiver chan<- bool) {
+	_driver.PollObstructionSwitch(receiver)
+}
+
+func (d *Driver) PollObstructionSwitch(receiver chan<- bool) {
 	prevObstructionState := false
-	for {
-		time.Sleep(_pollRate)
-		obstructionActiv := GetObstruction()
+	for d.wait() {
+		obstructionActiv := d.GetObstruction()
 		if obstructionActiv != prevObstructionState {
-			receiver <- obstructionActiv
+			select {
+			case receiver <- obstructionActiv:
+			case <-d.done:
+				return
+			}
 		}
 		prevObstructionState = obstructionActiv
 	}
 }
 
 func GetButton(button ButtonType, floor int) bool {
-	response := read([4]byte{6, byte(button), byte(floor), 0})
+	return _driver.GetButton(button, floor)
+}
+
+func (d *Driver) GetButton(button ButtonType, floor int) bool {
+	response := d.read([4]byte{6, byte(button), byte(floor), 0})
 	return toBool(response[1])
 }
 
 func GetFloor() int {
-	response := read([4]byte{7, 0, 0, 0})
+	return _driver.GetFloor()
+}
+
+func (d *Driver) GetFloor() int {
+	response := d.read([4]byte{7, 0, 0, 0})
 	if response[1] != 0 {
 		return int(response[2])
 	} else {
@@ -150,26 +260,37 @@ func GetFloor() int {
 }
 
 func GetStop() bool {
-	response := read([4]byte{8, 0, 0, 0})
+	return _driver.GetStop()
+}
+
+func (d *Driver) GetStop() bool {
+	response := d.read([4]byte{8, 0, 0, 0})
 	return toBool(response[1])
 }
 
 func GetObstruction() bool {
-	response := read([4]byte{9, 0, 0, 0})
+	return _driver.GetObstruction()
+}
+
+func (d *Driver) GetObstruction() bool {
+	response := d.read([4]byte{9, 0, 0, 0})
 	return toBool(response[1])
 }
 
-func read(in [4]byte) [4]byte {
-	_mtx.Lock()
-	defer _mtx.Unlock()
+func (d *Driver) read(in [4]byte) [4]byte {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.detached || d.closed {
+		return [4]byte{}
+	}
 
-	_, err := _conn.Write(in[:])
+	_, err := d.conn.Write(in[:])
 	if err != nil {
 		panic("Lost connection to Elevator Server")
 	}
 
 	var out [4]byte
-	_, err = _conn.Read(out[:])
+	_, err = d.conn.Read(out[:])
 	if err != nil {
 		panic("Lost connection to Elevator Server")
 	}
@@ -177,11 +298,14 @@ func read(in [4]byte) [4]byte {
 	return out
 }
 
-func write(in [4]byte) {
-	_mtx.Lock()
-	defer _mtx.Unlock()
+func (d *Driver) write(in [4]byte) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.detached || d.closed {
+		return
+	}
 
-	_, err := _conn.Write(in[:])
+	_, err := d.conn.Write(in[:])
 	if err != nil {
 		panic("Lost connection to Elevator Server")
 	}
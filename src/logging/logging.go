@@ -0,0 +1,124 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Structured logging for every package. Each subsystem has a logger from For and a level of its own,
+// and once main knows the node, every record carries its ID and incarnation.
+//
+//	-log-levels "net=debug,cost=warn"
+
+const (
+	Main = "main"
+	Elev = "elev"
+	Cost = "cost"
+	Net  = "net"
+)
+
+var levels = map[string]*slog.LevelVar{Main: {}, Elev: {}, Cost: {}, Net: {}}
+
+// Where records go, swapped by Setup and SetNode. Loggers look it up for every record,
+// so the ones made before Setup follow along.
+var output atomic.Pointer[slog.Handler]
+
+func init() {
+	var h slog.Handler = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})
+	output.Store(&h)
+}
+
+// Sends records to w as "text" or "json", with every subsystem at level except the ones in
+// overrides, written as comma separated subsystem=level
+func Setup(w io.Writer, format string, level slog.Level, overrides string) error {
+	var h slog.Handler
+	options := &slog.HandlerOptions{Level: slog.LevelDebug} //Levels are checked per subsystem
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(w, options)
+	case "json":
+		h = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+	for _, v := range levels {
+		v.Set(level)
+	}
+	for _, override := range strings.Split(overrides, ",") {
+		if strings.TrimSpace(override) == "" {
+			continue
+		}
+		subsystem, name, found := strings.Cut(strings.TrimSpace(override), "=")
+		v, known := levels[subsystem]
+		if !found || !known {
+			return fmt.Errorf("bad log level %q, expected <subsystem>=<level> with subsystem %s, %s, %s or %s", override, Main, Elev, Cost, Net)
+		}
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(name)); err != nil {
+			return err
+		}
+		v.Set(l)
+	}
+	output.Store(&h)
+	return nil
+}
+
+// Adds the node to every record from now on
+func SetNode(id string, incarnation uint32) {
+	h := (*output.Load()).WithAttrs([]slog.Attr{slog.String("node", id), slog.Any("incarnation", incarnation)})
+	output.Store(&h)
+}
+
+func For(subsystem string) *slog.Logger {
+	level, ok := levels[subsystem]
+	if !ok {
+		panic(fmt.Sprintf("logging: unknown subsystem %q", subsystem))
+	}
+	return slog.New(&subsystemHandler{level: level}).With("subsystem", subsystem)
+}
+
+type subsystemHandler struct {
+	level  *slog.LevelVar
+	attrs  []slog.Attr
+	groups []string
+}
+
+func (h *subsystemHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := (*output.Load()).WithAttrs(h.attrs)
+	for _, group := range h.groups {
+		out = out.WithGroup(group)
+	}
+	return out.Handle(ctx, r)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(h.groups) > 0 {
+		attrs = []slog.Attr{nest(h.groups, attrs)}
+	}
+	return &subsystemHandler{level: h.level, attrs: append(append([]slog.Attr(nil), h.attrs...), attrs...), groups: h.groups}
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return &subsystemHandler{level: h.level, attrs: h.attrs, groups: append(append([]string(nil), h.groups...), name)}
+}
+
+func nest(groups []string, attrs []slog.Attr) slog.Attr {
+	args := make([]any, len(attrs))
+	for i, attr := range attrs {
+		args[i] = attr
+	}
+	nested := slog.Group(groups[len(groups)-1], args...)
+	for i := len(groups) - 2; i >= 0; i-- {
+		nested = slog.Group(groups[i], nested)
+	}
+	return nested
+}
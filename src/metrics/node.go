@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	cost "heis/src/cost_func"
+	"heis/src/elev"
+	"heis/src/logging"
+	"heis/src/network/bcast"
+	"heis/src/network/reliable"
+	"net/http"
+	"time"
+)
+
+var logger = logging.For(logging.Main).With("component", "metrics")
+
+var orderStates = map[elev.OrderStatus]string{
+	elev.Order_Inactive:        "inactive",
+	elev.Order_Pending:         "pending",
+	elev.Order_Active:          "active",
+	elev.Order_PendingInactive: "pending_inactive",
+}
+
+// The metrics of one node. The main loop hands it the elevator after every event, and events like door
+// cycles are counted from the changes between two of them.
+type Node struct {
+	*Registry
+	previous    elev.Elevator
+	hallPlaced  [][2]time.Time
+	initialized bool
+}
+
+func NewNode() *Node {
+	r := NewRegistry()
+	r.Gauge("heis_orders", "Orders known to this node, by kind and state")
+	r.Histogram("heis_hall_wait_seconds", "Time from a hall call being placed until it was served", []float64{1, 2.5, 5, 10, 20, 30, 60, 120})
+	r.Counter("heis_door_cycles_total", "Times the door has opened")
+	r.Counter("heis_floors_traveled_total", "Floors passed by this elevator")
+	r.Counter("heis_motor_stuck_total", "Times the elevator has been taken out of service as stuck")
+	r.Counter("heis_obstruction_events_total", "Times the obstruction switch has been turned on")
+	r.Gauge("heis_floor", "Last floor the elevator was at")
+	r.Gauge("heis_stuck", "1 while the elevator is out of service")
+	r.Gauge("heis_peers", "Other nodes that are alive")
+	r.Gauge("heis_peer_last_seen_seconds", "Time since the last status message from each alive node")
+	r.Counter("heis_messages_sent_total", "Messages broadcast, by topic")
+	r.Counter("heis_messages_received_total", "Messages received and decoded, by topic")
+	r.Counter("heis_message_decode_errors_total", "Messages received that could not be decoded, by topic")
+	r.Counter("heis_messages_unknown_total", "Messages received for topics this node doesn't have")
+	r.Counter("heis_datagrams_rejected_total", "Datagrams dropped by authentication, by reason")
+	r.Gauge("heis_reliable_pending", "Reliable messages sent and not acked yet")
+	r.Histogram("heis_assigner_duration_seconds", "Time the hall request assigner took", []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1})
+	r.Counter("heis_assigner_failures_total", "Times the assigner failed and the fallback was used")
+	r.Gauge("heis_assigner_healthy", "0 while the fallback assigner is in use")
+	return &Node{Registry: r}
+}
+
+// Serves the metrics on http://addr/metrics until it fails
+func Serve(addr string, r *Registry) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	logger.Info("Serving metrics", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logger.Error("Metrics server failed", "err", err)
+	}
+}
+
+// Reads the message counts of the bus and the reliable node at every scrape
+func (m *Node) WatchNetwork(bus *bcast.Bus, reliableNode *reliable.Node) {
+	m.OnScrape(func() {
+		for topic, stats := range bus.Stats() {
+			m.Set("heis_messages_sent_total", float64(stats.Sent), "topic", topic)
+			m.Set("heis_messages_received_total", float64(stats.Received), "topic", topic)
+			m.Set("heis_message_decode_errors_total", float64(stats.DecodeErrors), "topic", topic)
+		}
+		m.Set("heis_messages_unknown_total", float64(bus.Unknown()))
+		for reason, count := range bcast.Rejections() {
+			m.Set("heis_datagrams_rejected_total", float64(count), "reason", reason)
+		}
+		m.Set("heis_reliable_pending", float64(reliableNode.Pending()))
+	})
+}
+
+// Call from the main loop after every event, after UpdateOrderTimes
+func (m *Node) ObserveElevator(e *elev.Elevator) {
+	counts := make(map[[2]string]int)
+	for _, row := range e.Orders.ListHall {
+		for _, status := range row {
+			counts[[2]string{"hall", orderStates[status]}]++
+		}
+	}
+	for _, status := range e.Orders.ListCab {
+		counts[[2]string{"cab", orderStates[status]}]++
+	}
+	for _, row := range e.Orders.ListDest {
+		for _, status := range row {
+			counts[[2]string{"destination", orderStates[status]}]++
+		}
+	}
+	for _, kind := range []string{"hall", "cab", "destination"} {
+		for _, state := range orderStates {
+			m.Set("heis_orders", float64(counts[[2]string{kind, state}]), "kind", kind, "state", state)
+		}
+	}
+
+	//A hall call is served when its placed time is cleared
+	now := time.Now()
+	for floor := range e.Orders.TimeHall {
+		for button := 0; button < 2; button++ {
+			placed := e.Orders.TimeHall[floor][button]
+			if floor < len(m.hallPlaced) {
+				if before := m.hallPlaced[floor][button]; !before.IsZero() && placed.IsZero() {
+					m.Observe("heis_hall_wait_seconds", now.Sub(before).Seconds())
+				}
+			}
+		}
+	}
+	m.hallPlaced = append(m.hallPlaced[:0], e.Orders.TimeHall...)
+
+	if m.initialized {
+		if e.State.DoorOpen && !m.previous.State.DoorOpen {
+			m.Add("heis_door_cycles_total", 1)
+		}
+		if e.State.Floor != m.previous.State.Floor && m.previous.State.Floor >= 0 && e.State.Floor >= 0 {
+			m.Add("heis_floors_traveled_total", float64(max(e.State.Floor-m.previous.State.Floor, m.previous.State.Floor-e.State.Floor)))
+		}
+		if e.State.Stuck && !m.previous.State.Stuck {
+			m.Add("heis_motor_stuck_total", 1)
+		}
+		if e.State.Obstructed && !m.previous.State.Obstructed {
+			m.Add("heis_obstruction_events_total", 1)
+		}
+	}
+	m.previous.State = e.State
+	m.initialized = true
+
+	m.Set("heis_floor", float64(e.State.Floor))
+	m.Set("heis_stuck", boolValue(e.State.Stuck))
+}
+
+// lastHeard has when the last status message from each node arrived
+func (m *Node) ObservePeers(alive map[string]bool, lastHeard map[string]time.Time) {
+	m.Clear("heis_peer_last_seen_seconds")
+	count := 0
+	for id, isAlive := range alive {
+		if !isAlive {
+			continue
+		}
+		count++
+		if heard, ok := lastHeard[id]; ok {
+			m.Set("heis_peer_last_seen_seconds", time.Since(heard).Seconds(), "peer", id)
+		}
+	}
+	m.Set("heis_peers", float64(count))
+}
+
+// Call after every run of the assigner
+func (m *Node) Assigned(duration time.Duration, health cost.Health) {
+	m.Observe("heis_assigner_duration_seconds", duration.Seconds())
+	m.Set("heis_assigner_failures_total", float64(health.Failures))
+	m.Set("heis_assigner_healthy", boolValue(health.Healthy))
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,221 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A small registry that writes the Prometheus text format, enough for counters, gauges and
+// histograms with labels. Labels are given as name, value pairs:
+//
+//	r.Counter("heis_door_cycles_total", "Times the door has opened")
+//	r.Add("heis_door_cycles_total", 1)
+//	r.Set("heis_orders", 2, "kind", "hall", "state", "active")
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+	byName   map[string]*family
+	onScrape []func()
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	buckets []float64
+	series  map[string]*series //By rendered labels
+}
+
+type series struct {
+	labels  string //Like `peer="a"`, without braces
+	value   float64
+	buckets []uint64
+	count   uint64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{byName: make(map[string]*family)}
+}
+
+func (r *Registry) Counter(name string, help string) {
+	r.register(&family{name: name, help: help, kind: kindCounter})
+}
+
+func (r *Registry) Gauge(name string, help string) {
+	r.register(&family{name: name, help: help, kind: kindGauge})
+}
+
+// Buckets are the upper bounds, in increasing order
+func (r *Registry) Histogram(name string, help string, buckets []float64) {
+	r.register(&family{name: name, help: help, kind: kindHistogram, buckets: buckets})
+}
+
+func (r *Registry) register(f *family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.byName[f.name]; exists {
+		panic(fmt.Sprintf("metrics: %s is already registered", f.name))
+	}
+	f.series = make(map[string]*series)
+	r.families = append(r.families, f)
+	r.byName[f.name] = f
+}
+
+// Runs before every scrape, for metrics that are read from elsewhere rather than updated as they change.
+// It runs on the HTTP server's goroutine.
+func (r *Registry) OnScrape(collect func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onScrape = append(r.onScrape, collect)
+}
+
+func (r *Registry) Add(name string, v float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.get(name, labels).value += v
+}
+
+func (r *Registry) Set(name string, v float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.get(name, labels).value = v
+}
+
+func (r *Registry) Observe(name string, v float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.get(name, labels)
+	f := r.byName[name]
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(f.buckets))
+	}
+	for i, bound := range f.buckets {
+		if v <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.value += v
+	s.count++
+}
+
+// Drops every series of the metric, for labels that come and go, like peers
+func (r *Registry) Clear(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byName[name].series = make(map[string]*series)
+}
+
+// Call with mu held
+func (r *Registry) get(name string, labels []string) *series {
+	f, ok := r.byName[name]
+	if !ok {
+		panic(fmt.Sprintf("metrics: %s is not registered", name))
+	}
+	if len(labels)%2 != 0 {
+		panic(fmt.Sprintf("metrics: %s: labels must come in name, value pairs", name))
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	key := strings.Join(pairs, ",")
+	s, exists := f.series[key]
+	if !exists {
+		s = &series{labels: key}
+		f.series[key] = s
+	}
+	return s
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := r.onScrape
+	r.mu.Unlock()
+	for _, collect := range collectors {
+		collect()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counter := &countingWriter{w: w}
+	out := bufio.NewWriter(counter)
+	for _, f := range r.families {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			if f.kind != kindHistogram {
+				fmt.Fprintf(out, "%s%s %s\n", f.name, braces(s.labels), formatValue(s.value))
+				continue
+			}
+			for i, bound := range f.buckets {
+				fmt.Fprintf(out, "%s_bucket%s %d\n", f.name, braces(withLabel(s.labels, "le", formatValue(bound))), s.buckets[i])
+			}
+			fmt.Fprintf(out, "%s_bucket%s %d\n", f.name, braces(withLabel(s.labels, "le", "+Inf")), s.count)
+			fmt.Fprintf(out, "%s_sum%s %s\n", f.name, braces(s.labels), formatValue(s.value))
+			fmt.Fprintf(out, "%s_count%s %d\n", f.name, braces(s.labels), s.count)
+		}
+	}
+	err := out.Flush()
+	return counter.n, err
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteTo(w)
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func withLabel(labels string, name string, value string) string {
+	if labels == "" {
+		return name + `="` + value + `"`
+	}
+	return labels + "," + name + `="` + value + `"`
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
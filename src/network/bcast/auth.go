@@ -0,0 +1,140 @@
+package bcast
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// Pre-shared cluster key. When set, every datagram is sent as
+//
+//	authMagic | send time (8 bytes, unix ns) | nonce (8 bytes) | packet | HMAC-SHA256 of everything before it
+//
+// and received datagrams without a valid HMAC, older than authWindow or seen before are dropped.
+// Every node in the cluster needs the same key.
+var Key []byte
+
+const (
+	authMagic      = 0xa5
+	authHeaderSize = 17
+	authOverhead   = authHeaderSize + sha256.Size
+	authWindow     = 5 * time.Second //Allowed clock difference between nodes, and how long nonces are remembered
+)
+
+// Reasons a datagram was dropped by authentication
+const (
+	RejectUnauthenticated = "unauthenticated"
+	RejectBadMAC          = "bad_mac"
+	RejectStale           = "stale"
+	RejectReplayed        = "replayed"
+)
+
+var rejections = struct {
+	sync.Mutex
+	counts map[string]uint64
+}{counts: make(map[string]uint64)}
+
+// Number of datagrams dropped for each reason since start
+func Rejections() map[string]uint64 {
+	rejections.Lock()
+	defer rejections.Unlock()
+	counts := make(map[string]uint64, len(rejections.counts))
+	for reason, count := range rejections.counts {
+		counts[reason] = count
+	}
+	return counts
+}
+
+// Logs the 1st, 10th, 100th... rejection for each reason, so a flood doesn't flood the log as well
+func reject(port int, reason string, from string) {
+	rejections.Lock()
+	rejections.counts[reason]++
+	count := rejections.counts[reason]
+	rejections.Unlock()
+	for n := uint64(1); n <= count; n *= 10 {
+		if n == count {
+			logger.Warn("Dropped datagram", "reason", reason, "from", from, "port", port, "count", count)
+		}
+	}
+}
+
+// Seal and Opener authenticate datagrams for Transmitter and Receiver, and for other protocols that share
+// the cluster key, like peers. Without a key they pass datagrams through unchanged.
+
+func Seal(packet []byte) []byte {
+	if Key == nil {
+		return packet
+	}
+	return sign(Key, packet, time.Now())
+}
+
+// Returns a function that gives the packet inside a sealed datagram, or nil if it was rejected
+func Opener(port int) func(datagram []byte, from string) []byte {
+	if Key == nil {
+		return func(datagram []byte, from string) []byte { return datagram }
+	}
+	v := newVerifier(Key)
+	return func(datagram []byte, from string) []byte {
+		packet, reason := v.verify(datagram, time.Now())
+		if packet == nil {
+			reject(port, reason, from)
+		}
+		return packet
+	}
+}
+
+func sign(key []byte, packet []byte, now time.Time) []byte {
+	datagram := make([]byte, authHeaderSize, authOverhead+len(packet))
+	datagram[0] = authMagic
+	binary.BigEndian.PutUint64(datagram[1:9], uint64(now.UnixNano()))
+	rand.Read(datagram[9:17])
+	datagram = append(datagram, packet...)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(datagram)
+	return mac.Sum(datagram)
+}
+
+type verifier struct {
+	key    []byte
+	nonces map[uint64]time.Time
+	pruned time.Time
+}
+
+func newVerifier(key []byte) *verifier {
+	return &verifier{key: key, nonces: make(map[uint64]time.Time)}
+}
+
+// Returns the packet inside an authenticated datagram, or the reason it was rejected
+func (v *verifier) verify(datagram []byte, now time.Time) ([]byte, string) {
+	if len(datagram) < authOverhead || datagram[0] != authMagic {
+		return nil, RejectUnauthenticated
+	}
+	signed, received := datagram[:len(datagram)-sha256.Size], datagram[len(datagram)-sha256.Size:]
+	mac := hmac.New(sha256.New, v.key)
+	mac.Write(signed)
+	if !hmac.Equal(mac.Sum(nil), received) {
+		return nil, RejectBadMAC
+	}
+
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(datagram[1:9])))
+	if sent.Before(now.Add(-authWindow)) || sent.After(now.Add(authWindow)) {
+		return nil, RejectStale
+	}
+	if now.Sub(v.pruned) > authWindow {
+		for nonce, seen := range v.nonces {
+			if now.Sub(seen) > 2*authWindow {
+				delete(v.nonces, nonce)
+			}
+		}
+		v.pruned = now
+	}
+	nonce := binary.BigEndian.Uint64(datagram[9:17])
+	if _, seen := v.nonces[nonce]; seen {
+		return nil, RejectReplayed
+	}
+	v.nonces[nonce] = now
+	return signed[authHeaderSize:], ""
+}
@@ -1,18 +1,41 @@
 package bcast
 
 import (
+	"heis/src/logging"
 	"heis/src/network/conn"
+	"encoding"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
-	"net"
+	"hash/fnv"
+	"math/rand"
 	"reflect"
+	"time"
 )
 
 const bufSize = 1024
 
+var logger = logging.For(logging.Net).With("component", "bcast")
+
+// Values that implement encoding.BinaryMarshaler are sent in their own binary form instead of
+// type-tagged JSON. Receivers understand both, so nodes with different settings can still talk.
+var BinaryEncoding = false
+
+// Binary packets are `binaryMagic`, the protocol major and minor version, a hash of the type name and the payload.
+// JSON never starts with this byte.
+const (
+	binaryMagic      = 0xb1
+	binaryHeaderSize = 7
+)
+
 // Encodes received values from `chans` into type-tagged JSON, then broadcasts
-// it on `port`
+// it on `port`, or sends it where conn.Config says
 func Transmitter(port int, chans ...interface{}) {
+	TransmitterOn(conn.UDP{}, port, chans...)
+}
+
+// Like Transmitter, over the given transport
+func TransmitterOn(transport conn.Transport, port int, chans ...interface{}) {
 	checkArgs(chans...)
 	typeNames := make([]string, len(chans))
 	selectCases := make([]reflect.SelectCase, len(typeNames))
@@ -24,22 +47,42 @@ func Transmitter(port int, chans ...interface{}) {
 		typeNames[i] = reflect.TypeOf(ch).Elem().String()
 	}
 
-	conn := conn.DialBroadcastUDP(port)
-	addr, _ := net.ResolveUDPAddr("udp4", fmt.Sprintf("255.255.255.255:%d", port))
+	addrs := transport.Destinations(port)
+	conn := transport.Dial(port)
+	messageID := rand.Uint32()
+	send := func(packet []byte) {
+		packet = Seal(packet)
+		for _, addr := range addrs {
+			conn.WriteTo(packet, addr)
+		}
+	}
 	for {
 		chosen, value, _ := reflect.Select(selectCases)
-		jsonstr, _ := json.Marshal(value.Interface())
-		ttj, _ := json.Marshal(typeTaggedJSON{
-			TypeId: typeNames[chosen],
-			JSON:   jsonstr,
-		})
-		if len(ttj) > bufSize {
-		    panic(fmt.Sprintf(
-		        "Tried to send a message longer than the buffer size (length: %d, buffer size: %d)\n\t'%s'\n"+
-		        "Either send smaller packets, or go to network/bcast/bcast.go and increase the buffer size",
-		        len(ttj), bufSize, string(ttj)))
+		var packet []byte
+		if marshaler, ok := value.Interface().(encoding.BinaryMarshaler); ok && BinaryEncoding {
+			payload, err := marshaler.MarshalBinary()
+			if err != nil {
+				logger.Error("MarshalBinary failed", "port", port, "err", err)
+				continue
+			}
+			packet = append(binaryHeader(typeNames[chosen]), payload...)
+		} else {
+			jsonstr, _ := json.Marshal(value.Interface())
+			packet, _ = json.Marshal(typeTaggedJSON{
+				TypeId: typeNames[chosen],
+				Major:  ProtocolMajor,
+				Minor:  ProtocolMinor,
+				JSON:   jsonstr,
+			})
+		}
+		if len(packet) > maxPacketSize {
+			messageID++
+			for _, f := range fragment(packet, messageID) {
+				send(f)
+			}
+			continue
 		}
-		conn.WriteTo(ttj, addr)
+		send(packet)
     		
 	}
 }
@@ -47,28 +90,74 @@ func Transmitter(port int, chans ...interface{}) {
 // Matches type-tagged JSON received on `port` to element types of `chans`, then
 // sends the decoded value on the corresponding channel
 func Receiver(port int, chans ...interface{}) {
+	ReceiverOn(conn.UDP{}, port, chans...)
+}
+
+// Like Receiver, over the given transport
+func ReceiverOn(transport conn.Transport, port int, chans ...interface{}) {
 	checkArgs(chans...)
 	chansMap := make(map[string]interface{})
+	binaryChansMap := make(map[uint32]interface{})
 	for _, ch := range chans {
 		chansMap[reflect.TypeOf(ch).Elem().String()] = ch
+		if reflect.PointerTo(reflect.TypeOf(ch).Elem()).Implements(reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()) {
+			binaryChansMap[typeHash(reflect.TypeOf(ch).Elem().String())] = ch
+		}
 	}
 
 	var buf [bufSize]byte
-	conn := conn.DialBroadcastUDP(port)
+	fragments := newReassembler()
+	open := Opener(port)
+	conn := transport.Dial(port)
 	for {
-		n, _, e := conn.ReadFrom(buf[0:])
+		n, from, e := conn.ReadFrom(buf[0:])
 		if e != nil {
-			fmt.Printf("bcast.Receiver(%d, ...):ReadFrom() failed: \"%+v\"\n", port, e)
+			logger.Error("ReadFrom failed", "port", port, "err", e)
+			continue
 		}
 
-		var ttj typeTaggedJSON
-		json.Unmarshal(buf[0:n], &ttj)
-		ch, ok := chansMap[ttj.TypeId]
-		if !ok {
+		packet := open(buf[0:n], from.String())
+		if packet == nil {
 			continue
 		}
-		v := reflect.New(reflect.TypeOf(ch).Elem())
-		json.Unmarshal(ttj.JSON, v.Interface())
+		if len(packet) > 0 && packet[0] == fragmentMagic {
+			if packet = fragments.add(from.String(), packet, time.Now()); packet == nil {
+				continue
+			}
+		}
+
+		var ch interface{}
+		var v reflect.Value
+		if len(packet) >= binaryHeaderSize && packet[0] == binaryMagic {
+			if !Compatible(int(packet[1]), int(packet[2]), from.String()) {
+				continue
+			}
+			var ok bool
+			ch, ok = binaryChansMap[binary.BigEndian.Uint32(packet[3:7])]
+			if !ok {
+				continue
+			}
+			v = reflect.New(reflect.TypeOf(ch).Elem())
+			if err := v.Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(packet[binaryHeaderSize:]); err != nil {
+				logger.Warn("UnmarshalBinary failed", "port", port, "from", from.String(), "err", err)
+				continue
+			}
+		} else {
+			var ttj typeTaggedJSON
+			if err := json.Unmarshal(packet, &ttj); err != nil {
+				continue
+			}
+			if !Compatible(ttj.Major, ttj.Minor, from.String()) {
+				continue
+			}
+			var ok bool
+			ch, ok = chansMap[ttj.TypeId]
+			if !ok {
+				continue
+			}
+			v = reflect.New(reflect.TypeOf(ch).Elem())
+			json.Unmarshal(ttj.JSON, v.Interface())
+		}
 		reflect.Select([]reflect.SelectCase{{
 			Dir:  reflect.SelectSend,
 			Chan: reflect.ValueOf(ch),
@@ -79,9 +168,21 @@ func Receiver(port int, chans ...interface{}) {
 
 type typeTaggedJSON struct {
 	TypeId string
+	Major  int
+	Minor  int
 	JSON   []byte
 }
 
+func typeHash(typeName string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(typeName))
+	return h.Sum32()
+}
+
+func binaryHeader(typeName string) []byte {
+	return binary.BigEndian.AppendUint32([]byte{binaryMagic, ProtocolMajor, ProtocolMinor}, typeHash(typeName))
+}
+
 // Checks that args to Tx'er/Rx'er are valid:
 //  All args must be channels
 //  Element types of channels must be encodable with JSON
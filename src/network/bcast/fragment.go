@@ -0,0 +1,129 @@
+package bcast
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Packets longer than maxPacketSize are split into fragments that each fit in one datagram:
+//
+//	fragmentMagic | message ID (4 bytes) | fragment index (2 bytes) | fragment count (2 bytes) | part of the packet
+//
+// The receiver puts them back together per sender and message ID. A message that is still missing
+// fragments after reassemblyTimeout is dropped, and so are the oldest ones if they take up more than maxReassemblyBytes.
+
+const (
+	fragmentMagic      = 0xf7
+	fragmentHeaderSize = 9
+	maxPacketSize      = bufSize - authOverhead //Room for authentication, whether it is used or not
+	fragmentPayload    = maxPacketSize - fragmentHeaderSize
+	maxFragments       = 64
+	reassemblyTimeout  = 1 * time.Second
+	maxReassemblyBytes = 1 << 20
+)
+
+// Nil if the packet needs more than maxFragments, then it is dropped
+func fragment(packet []byte, messageID uint32) [][]byte {
+	count := (len(packet) + fragmentPayload - 1) / fragmentPayload
+	if count > maxFragments {
+		logger.Error("Dropped message that is too long", "length", len(packet), "max", maxFragments*fragmentPayload)
+		return nil
+	}
+	fragments := make([][]byte, 0, count)
+	for index := 0; index < count; index++ {
+		end := min((index+1)*fragmentPayload, len(packet))
+		header := make([]byte, fragmentHeaderSize, fragmentHeaderSize+end-index*fragmentPayload)
+		header[0] = fragmentMagic
+		binary.BigEndian.PutUint32(header[1:5], messageID)
+		binary.BigEndian.PutUint16(header[5:7], uint16(index))
+		binary.BigEndian.PutUint16(header[7:9], uint16(count))
+		fragments = append(fragments, append(header, packet[index*fragmentPayload:end]...))
+	}
+	return fragments
+}
+
+type fragmentKey struct {
+	sender    string
+	messageID uint32
+}
+
+type partialMessage struct {
+	fragments [][]byte
+	received  int
+	size      int
+	started   time.Time
+}
+
+type reassembler struct {
+	partial map[fragmentKey]*partialMessage
+	size    int
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{partial: make(map[fragmentKey]*partialMessage)}
+}
+
+// Stores one fragment from sender, and returns the whole packet once every fragment of it has arrived
+func (r *reassembler) add(sender string, data []byte, now time.Time) []byte {
+	r.expire(now)
+	if len(data) < fragmentHeaderSize {
+		return nil
+	}
+	key := fragmentKey{sender: sender, messageID: binary.BigEndian.Uint32(data[1:5])}
+	index := int(binary.BigEndian.Uint16(data[5:7]))
+	count := int(binary.BigEndian.Uint16(data[7:9]))
+	if count == 0 || count > maxFragments || index >= count {
+		return nil
+	}
+
+	message, exists := r.partial[key]
+	if !exists {
+		message = &partialMessage{fragments: make([][]byte, count), started: now}
+		r.partial[key] = message
+	}
+	if len(message.fragments) != count || message.fragments[index] != nil {
+		return nil
+	}
+	message.fragments[index] = append([]byte(nil), data[fragmentHeaderSize:]...)
+	message.received++
+	message.size += len(data) - fragmentHeaderSize
+	r.size += len(data) - fragmentHeaderSize
+
+	if message.received < count {
+		r.evict()
+		return nil
+	}
+	r.remove(key)
+	packet := make([]byte, 0, message.size)
+	for _, part := range message.fragments {
+		packet = append(packet, part...)
+	}
+	return packet
+}
+
+func (r *reassembler) remove(key fragmentKey) {
+	r.size -= r.partial[key].size
+	delete(r.partial, key)
+}
+
+func (r *reassembler) expire(now time.Time) {
+	for key, message := range r.partial {
+		if now.Sub(message.started) > reassemblyTimeout {
+			r.remove(key)
+		}
+	}
+}
+
+// Drops the oldest messages until the rest fit in maxReassemblyBytes
+func (r *reassembler) evict() {
+	for r.size > maxReassemblyBytes {
+		var oldest fragmentKey
+		var oldestStart time.Time
+		for key, message := range r.partial {
+			if oldestStart.IsZero() || message.started.Before(oldestStart) {
+				oldest, oldestStart = key, message.started
+			}
+		}
+		r.remove(oldest)
+	}
+}
@@ -0,0 +1,266 @@
+package bcast
+
+import (
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"heis/src/network/conn"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Typed broadcasts, the alternative to Transmitter and Receiver without reflection:
+//
+//	bus := bcast.Open(port)
+//	status := bcast.NewTopic[elev.ElevatorMessage](bus, "status")
+//	status.Send(msg)
+//	msg := <-status.Recv()
+//
+// Topics are told apart by their name, in the TypeId of type-tagged JSON and the hash of binary packets,
+// so a type can be renamed without breaking the protocol. Every node has to use the same names.
+
+// The socket on one port, shared by all the topics on it
+type Bus struct {
+	port    int
+	conn    net.PacketConn
+	addrs   []net.Addr
+	done    <-chan struct{} //Of the transport, the bus stops when it closes
+	sendMu  sync.Mutex
+	nextID  uint32 //Message ID for fragments
+	mu      sync.RWMutex
+	topics  map[string]*topicEntry
+	hashes  map[uint32]*topicEntry
+	unknown atomic.Uint64
+}
+
+type topicEntry struct {
+	name    string
+	stats   *topicStats
+	deliver func(payload []byte, isBinary bool) error
+}
+
+type topicStats struct {
+	sent, received, decodeErrors atomic.Uint64
+}
+
+type TopicStats struct {
+	Sent         uint64
+	Received     uint64
+	DecodeErrors uint64 //Received but not understood, so dropped
+}
+
+func (s *topicStats) snapshot() TopicStats {
+	return TopicStats{Sent: s.sent.Load(), Received: s.received.Load(), DecodeErrors: s.decodeErrors.Load()}
+}
+
+// Opens the bus on port, sending where conn.Config says
+func Open(port int) *Bus {
+	return OpenOn(conn.UDP{}, port)
+}
+
+// Like Open, over the given transport
+func OpenOn(transport conn.Transport, port int) *Bus {
+	b := &Bus{
+		port:   port,
+		conn:   transport.Dial(port),
+		addrs:  transport.Destinations(port),
+		done:   transport.Done(),
+		nextID: rand.Uint32(),
+		topics: make(map[string]*topicEntry),
+		hashes: make(map[uint32]*topicEntry),
+	}
+	go b.receive()
+	return b
+}
+
+// Stats of every topic on the bus, by name
+func (b *Bus) Stats() map[string]TopicStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	stats := make(map[string]TopicStats, len(b.topics))
+	for name, entry := range b.topics {
+		stats[name] = entry.stats.snapshot()
+	}
+	return stats
+}
+
+// Number of messages received for topics that are not on this bus
+func (b *Bus) Unknown() uint64 {
+	return b.unknown.Load()
+}
+
+type Topic[T any] struct {
+	name  string
+	bus   *Bus
+	ch    chan T
+	stats *topicStats
+}
+
+// Adds the topic to the bus. Panics if the bus already has a topic with the name.
+func NewTopic[T any](bus *Bus, name string) *Topic[T] {
+	t := &Topic[T]{name: name, bus: bus, ch: make(chan T), stats: &topicStats{}}
+	entry := &topicEntry{name: name, stats: t.stats, deliver: t.deliver}
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if _, exists := bus.topics[name]; exists {
+		panic(fmt.Sprintf("bcast: topic %q is already on port %d", name, bus.port))
+	}
+	bus.topics[name] = entry
+	bus.hashes[typeHash(name)] = entry
+	return t
+}
+
+// Broadcasts v, in binary if BinaryEncoding is set and T has a binary form, otherwise as type-tagged JSON
+func (t *Topic[T]) Send(v T) {
+	var packet []byte
+	if marshaler, ok := any(v).(encoding.BinaryMarshaler); ok && BinaryEncoding {
+		payload, err := marshaler.MarshalBinary()
+		if err != nil {
+			logger.Error("MarshalBinary failed", "topic", t.name, "err", err)
+			return
+		}
+		packet = append(binaryHeader(t.name), payload...)
+	} else {
+		jsonstr, err := json.Marshal(v)
+		if err != nil {
+			logger.Error("Marshal failed", "topic", t.name, "err", err)
+			return
+		}
+		packet, _ = json.Marshal(typeTaggedJSON{
+			TypeId: t.name,
+			Major:  ProtocolMajor,
+			Minor:  ProtocolMinor,
+			JSON:   jsonstr,
+		})
+	}
+	if t.bus.send(packet) {
+		t.stats.sent.Add(1)
+	}
+}
+
+// Values received on the topic. The bus waits for each to be taken, so keep reading.
+func (t *Topic[T]) Recv() <-chan T {
+	return t.ch
+}
+
+func (t *Topic[T]) Stats() TopicStats {
+	return t.stats.snapshot()
+}
+
+func (t *Topic[T]) deliver(payload []byte, isBinary bool) error {
+	var v T
+	if isBinary {
+		unmarshaler, ok := any(&v).(encoding.BinaryUnmarshaler)
+		if !ok {
+			return fmt.Errorf("%T has no binary form", v)
+		}
+		if err := unmarshaler.UnmarshalBinary(payload); err != nil {
+			return err
+		}
+	} else if err := json.Unmarshal(payload, &v); err != nil {
+		return err
+	}
+	t.stats.received.Add(1)
+	select {
+	case t.ch <- v:
+	case <-t.bus.done:
+	}
+	return nil
+}
+
+// False if the packet was too long to send
+func (b *Bus) send(packet []byte) bool {
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+	if len(packet) <= maxPacketSize {
+		b.write(packet)
+		return true
+	}
+	b.nextID++
+	fragments := fragment(packet, b.nextID)
+	for _, f := range fragments {
+		b.write(f)
+	}
+	return fragments != nil
+}
+
+func (b *Bus) write(packet []byte) {
+	packet = Seal(packet)
+	for _, addr := range b.addrs {
+		b.conn.WriteTo(packet, addr)
+	}
+}
+
+func (b *Bus) receive() {
+	var buf [bufSize]byte
+	fragments := newReassembler()
+	open := Opener(b.port)
+	for {
+		n, from, err := b.conn.ReadFrom(buf[0:])
+		if err != nil {
+			select {
+			case <-b.done:
+				return
+			default:
+			}
+			logger.Error("ReadFrom failed", "port", b.port, "err", err)
+			continue
+		}
+		packet := open(buf[0:n], from.String())
+		if packet == nil {
+			continue
+		}
+		if len(packet) > 0 && packet[0] == fragmentMagic {
+			if packet = fragments.add(from.String(), packet, time.Now()); packet == nil {
+				continue
+			}
+		}
+
+		var entry *topicEntry
+		var payload []byte
+		isBinary := len(packet) >= binaryHeaderSize && packet[0] == binaryMagic
+		if isBinary {
+			if !Compatible(int(packet[1]), int(packet[2]), from.String()) {
+				continue
+			}
+			b.mu.RLock()
+			entry = b.hashes[binary.BigEndian.Uint32(packet[3:7])]
+			b.mu.RUnlock()
+			payload = packet[binaryHeaderSize:]
+		} else {
+			var ttj typeTaggedJSON
+			if err := json.Unmarshal(packet, &ttj); err != nil {
+				b.unknown.Add(1)
+				continue
+			}
+			if !Compatible(ttj.Major, ttj.Minor, from.String()) {
+				continue
+			}
+			b.mu.RLock()
+			entry = b.topics[ttj.TypeId]
+			b.mu.RUnlock()
+			payload = ttj.JSON
+		}
+		if entry == nil {
+			b.unknown.Add(1)
+			continue
+		}
+		if err := entry.deliver(payload, isBinary); err != nil {
+			entry.stats.decodeErrors.Add(1)
+			logDecodeError(entry.name, from.String(), err, entry.stats.decodeErrors.Load())
+		}
+	}
+}
+
+// Logs the 1st, 10th, 100th... decode error, like rejections
+func logDecodeError(topic string, from string, err error, count uint64) {
+	for n := uint64(1); n <= count; n *= 10 {
+		if n == count {
+			logger.Warn("Could not decode message", "topic", topic, "from", from, "count", count, "err", err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package bcast
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Protocol version carried by every message and heartbeat. Nodes drop everything from nodes with another
+// major version, and work together across minor versions, so a bank can be upgraded one node at a time.
+// Bump ProtocolMinor for changes older nodes can ignore, like a new optional field or a new message type,
+// and ProtocolMajor for anything else. Messages from before versioning count as major version 0.
+const (
+	ProtocolMajor = 3
+	ProtocolMinor = 1
+)
+
+var refusedSenders = struct {
+	sync.Mutex
+	seen map[string]bool
+}{seen: make(map[string]bool)}
+
+// True if a node with this version can be talked to. The first time a sender is refused it is logged.
+func Compatible(major int, minor int, sender string) bool {
+	if major == ProtocolMajor {
+		return true
+	}
+	refusedSenders.Lock()
+	defer refusedSenders.Unlock()
+	if !refusedSenders.seen[sender] {
+		refusedSenders.seen[sender] = true
+		logger.Error("Refusing sender with another protocol major version, upgrade the bank to the same major version",
+			"sender", sender, "protocol", fmt.Sprintf("%d.%d", major, minor), "local", fmt.Sprintf("%d.%d", ProtocolMajor, ProtocolMinor))
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package conn
+
+import (
+	"fmt"
+	"heis/src/logging"
+	"heis/src/network/localip"
+	"net"
+	"strconv"
+	"sync"
+)
+
+var logger = logging.For(logging.Net).With("component", "conn")
+
+// How datagrams are addressed, one of
+//
+//	broadcast  to Broadcast, or the directed broadcast address of Interface if it is set
+//	multicast  to Group, received on Interface, or the one localip picks. Loopback is on, so several nodes can run on one host.
+//	unicast    to every host in Peers
+const (
+	ModeBroadcast = "broadcast"
+	ModeMulticast = "multicast"
+	ModeUnicast   = "unicast"
+)
+
+type Addressing struct {
+	Mode      string
+	Broadcast string
+	Group     string
+	Interface string   //Network interface name, empty for the system default
+	Peers     []string //Host or host:port, without a port the one given to Dial is used
+	TTL       int      //Router hops for multicast
+	SendPort  int      //Port this node sends everything from, 0 to send from the shared ports
+}
+
+// Used by every Dial and Destinations call, set it before starting bcast or peers
+var Config = Addressing{
+	Mode:      ModeBroadcast,
+	Broadcast: "255.255.255.255",
+	Group:     "239.255.20.13",
+	TTL:       1,
+}
+
+func (a Addressing) Validate() error {
+	switch a.Mode {
+	case ModeBroadcast:
+		if a.Interface == "" && net.ParseIP(a.Broadcast).To4() == nil {
+			return fmt.Errorf("invalid broadcast address %q", a.Broadcast)
+		}
+	case ModeMulticast:
+		if ip := net.ParseIP(a.Group).To4(); ip == nil || !ip.IsMulticast() {
+			return fmt.Errorf("invalid multicast group %q", a.Group)
+		}
+	case ModeUnicast:
+		if len(a.Peers) == 0 {
+			return fmt.Errorf("unicast needs at least one peer")
+		}
+	default:
+		return fmt.Errorf("unknown mode %q, expected %s, %s or %s", a.Mode, ModeBroadcast, ModeMulticast, ModeUnicast)
+	}
+	if a.Interface != "" {
+		if _, err := net.InterfaceByName(a.Interface); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Opens a socket on port that receives what is sent to Destinations(port) by other nodes
+func Dial(port int) net.PacketConn {
+	conn := dial(port)
+	if Config.SendPort != 0 && conn != nil {
+		if send := nodeSocket.open(); send != nil {
+			conn = &sendingConn{PacketConn: conn, send: send}
+		}
+	}
+	if ImpairmentEnabled && conn != nil {
+		return impair(conn)
+	}
+	return conn
+}
+
+// Opens a socket on a port of its own, for datagrams sent straight to this node.
+// With SendPort set the first call gets the socket on SendPort.
+func DialUnicast() net.PacketConn {
+	conn := nodeSocket.take()
+	if conn == nil {
+		var err error
+		if conn, err = net.ListenPacket("udp4", ":0"); err != nil {
+			logger.Error("ListenPacket failed", "err", err)
+			return nil
+		}
+	}
+	if ImpairmentEnabled {
+		return impair(conn)
+	}
+	return conn
+}
+
+// Every node on a host sends from the shared ports, so the others can't tell them apart by address.
+// With SendPort set everything goes out of one socket on that port instead, so an impairment
+// for peer=:<port> covers that node alone.
+type sendSocket struct {
+	mu    sync.Mutex
+	conn  net.PacketConn
+	taken bool //By DialUnicast
+}
+
+var nodeSocket sendSocket
+
+func (s *sendSocket) open() net.PacketConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		s.conn = dial(Config.SendPort)
+		if s.conn != nil {
+			logger.Info("Sending from", "port", Config.SendPort)
+		}
+	}
+	return s.conn
+}
+
+// The socket, unless SendPort is unset or it has been taken already
+func (s *sendSocket) take() net.PacketConn {
+	if Config.SendPort == 0 {
+		return nil
+	}
+	conn := s.open()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.taken {
+		return nil
+	}
+	s.taken = true
+	return conn
+}
+
+// Receives on its own socket and sends from the node socket
+type sendingConn struct {
+	net.PacketConn
+	send net.PacketConn
+}
+
+func (c *sendingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.send.WriteTo(b, addr)
+}
+
+func dial(port int) net.PacketConn {
+	if Config.Mode != ModeMulticast {
+		return DialBroadcastUDP(port)
+	}
+
+	var ifi *net.Interface
+	if Config.Interface != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(Config.Interface); err != nil {
+			logger.Error("InterfaceByName failed", "err", err)
+		}
+	} else if local, err := localip.Lookup(""); err == nil && local.Interface.Flags&net.FlagMulticast != 0 {
+		ifi = &local.Interface //Works without a default route, unlike leaving it to the system
+	}
+	conn, err := net.ListenMulticastUDP("udp4", ifi, &net.UDPAddr{IP: net.ParseIP(Config.Group), Port: port})
+	if err != nil {
+		logger.Error("ListenMulticastUDP failed", "err", err)
+		return nil
+	}
+	raw, err := conn.SyscallConn()
+	if err == nil {
+		var optionsErr error
+		err = raw.Control(func(descriptor uintptr) {
+			optionsErr = setMulticastOptions(descriptor, Config.TTL)
+		})
+		if err == nil {
+			err = optionsErr
+		}
+	}
+	if err != nil {
+		logger.Error("Setting multicast options failed", "err", err)
+	}
+	return conn
+}
+
+// Where to send a datagram so every node listening on port gets it
+func Destinations(port int) []net.Addr {
+	var hosts []string
+	switch Config.Mode {
+	case ModeMulticast:
+		hosts = []string{Config.Group}
+	case ModeUnicast:
+		hosts = Config.Peers
+	default:
+		hosts = []string{Config.Broadcast}
+		if Config.Interface != "" {
+			broadcast, err := interfaceBroadcast(Config.Interface)
+			if err != nil {
+				logger.Error("Finding the broadcast address failed", "err", err)
+			} else {
+				hosts = []string{broadcast}
+			}
+		}
+	}
+
+	addrs := make([]net.Addr, 0, len(hosts))
+	for _, host := range hosts {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		addr, err := net.ResolveUDPAddr("udp4", host)
+		if err != nil {
+			logger.Error("ResolveUDPAddr failed", "err", err)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// Directed broadcast address of the first IPv4 network on the interface, like 10.100.23.255
+func interfaceBroadcast(name string) (string, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() == nil {
+			continue
+		}
+		ip := ipNet.IP.To4()
+		mask := ipNet.Mask[len(ipNet.Mask)-4:]
+		broadcast := make(net.IP, 4)
+		for i := range broadcast {
+			broadcast[i] = ip[i] | ^mask[i]
+		}
+		return broadcast.String(), nil
+	}
+	return "", fmt.Errorf("interface %s has no IPv4 address", name)
+}
@@ -0,0 +1,147 @@
+package conn
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// An in-memory network for running several nodes in one process, like in a test:
+//
+//	hub := conn.NewHub()
+//	a, b := hub.Node(), hub.Node()
+//	go bcast.TransmitterOn(a, 20013, statusOut)
+//	go bcast.ReceiverOn(b, 20013, statusIn)
+//
+// Every node gets an address of its own, and Destinations reaches every node listening on the port.
+// With Impaired set, delivery goes through the impairments, keyed by the address of the sending node.
+type Hub struct {
+	Impaired bool
+	mu       sync.Mutex
+	sockets  []*hubConn
+	nodes    int
+	nextPort int
+}
+
+const (
+	hubBroadcast      = "255.255.255.255"
+	hubFirstEphemeral = 49152
+)
+
+func NewHub() *Hub {
+	return &Hub{nextPort: hubFirstEphemeral}
+}
+
+// A transport for a new node on the hub, with the address 10.13.x.y
+func (h *Hub) Node() Transport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nodes++
+	return &hubNode{hub: h, ip: net.IPv4(10, 13, byte(h.nodes>>8), byte(h.nodes))}
+}
+
+type hubNode struct {
+	hub *Hub
+	ip  net.IP
+}
+
+func (n *hubNode) Dial(port int) net.PacketConn {
+	return n.hub.open(&net.UDPAddr{IP: n.ip, Port: port})
+}
+
+func (n *hubNode) DialUnicast() net.PacketConn {
+	n.hub.mu.Lock()
+	port := n.hub.nextPort
+	n.hub.nextPort++
+	n.hub.mu.Unlock()
+	return n.hub.open(&net.UDPAddr{IP: n.ip, Port: port})
+}
+
+func (n *hubNode) Done() <-chan struct{} {
+	return nil
+}
+
+func (n *hubNode) Destinations(port int) []net.Addr {
+	return []net.Addr{&net.UDPAddr{IP: net.ParseIP(hubBroadcast), Port: port}}
+}
+
+func (h *Hub) open(addr *net.UDPAddr) *hubConn {
+	c := &hubConn{queue: newQueue(), hub: h, addr: addr}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sockets = append(h.sockets, c)
+	return c
+}
+
+// Delivers to every socket on the port for a broadcast, like SO_REUSEADDR lets several nodes on one host
+// share it, or to the socket with the address
+func (h *Hub) send(data []byte, from *net.UDPAddr, to *net.UDPAddr) {
+	broadcast := to.IP.Equal(net.ParseIP(hubBroadcast))
+	h.mu.Lock()
+	var receivers []*hubConn
+	for _, c := range h.sockets {
+		if c.addr.Port == to.Port && (broadcast || c.addr.IP.Equal(to.IP)) {
+			receivers = append(receivers, c)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, c := range receivers {
+		d := datagram{data: append([]byte(nil), data...), from: from}
+		if h.Impaired {
+			deliverImpaired(d, c.deliver)
+		} else {
+			c.deliver(d)
+		}
+	}
+}
+
+func (h *Hub) remove(conn *hubConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, c := range h.sockets {
+		if c == conn {
+			h.sockets = append(h.sockets[:i], h.sockets[i+1:]...)
+			return
+		}
+	}
+}
+
+type hubConn struct {
+	*queue
+	hub  *Hub
+	addr *net.UDPAddr
+}
+
+func (c *hubConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	to, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, errors.New("hub: not a UDP address")
+	}
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	c.hub.send(b, c.addr, to)
+	return len(b), nil
+}
+
+func (c *hubConn) Close() error {
+	c.hub.remove(c)
+	c.close(net.ErrClosed)
+	return nil
+}
+
+func (c *hubConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *hubConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *hubConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
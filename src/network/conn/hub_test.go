@@ -0,0 +1,100 @@
+package conn_test
+
+import (
+	"heis/src/network/bcast"
+	"heis/src/network/conn"
+	"heis/src/network/peers"
+	"heis/src/network/reliable"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	testBcastPort    = 21013
+	testPeersPort    = 23013
+	testReliablePort = 24013
+)
+
+type greeting struct {
+	From string
+	Text string
+}
+
+type note struct {
+	To   string
+	Text string
+}
+
+func (n note) Recipient() string {
+	return n.To
+}
+
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no %s", what)
+		panic("unreachable")
+	}
+}
+
+// Three nodes on a hub: a broadcast reaches the two others, heartbeats make every node alive,
+// and a reliable message reaches only the node it is for
+func TestHubNodes(t *testing.T) {
+	peers.JoinDelay = 50 * time.Millisecond
+	hub := conn.NewHub()
+	ids := []string{"a", "b", "c"}
+	nodes := make(map[string]conn.Transport)
+	for _, id := range ids {
+		nodes[id] = hub.Node()
+	}
+
+	topics := make(map[string]*bcast.Topic[greeting])
+	peerUpdates := make(map[string]chan peers.PeerUpdate)
+	notes := make(map[string]chan note)
+	outgoing := make(chan note)
+	for _, id := range ids {
+		topics[id] = bcast.NewTopic[greeting](bcast.OpenOn(nodes[id], testBcastPort), "greeting")
+
+		peerUpdates[id] = make(chan peers.PeerUpdate, 16)
+		go peers.TransmitterOn(nodes[id], testPeersPort, id, make(chan bool))
+		go peers.ReceiverOn(nodes[id], testPeersPort, peerUpdates[id])
+
+		notes[id] = make(chan note, 1)
+		reliableNode := reliable.NewOn(nodes[id], testReliablePort, id)
+		if id == "a" {
+			go reliableNode.Transmitter(outgoing)
+		}
+		go reliableNode.Receiver(notes[id])
+	}
+
+	for _, id := range ids {
+		for {
+			update := receive(t, peerUpdates[id], "peer update at "+id)
+			if reflect.DeepEqual(update.Peers, ids) {
+				break
+			}
+		}
+	}
+
+	topics["a"].Send(greeting{From: "a", Text: "hello"})
+	for _, id := range []string{"b", "c"} {
+		if got := receive(t, topics[id].Recv(), "greeting at "+id); got != (greeting{From: "a", Text: "hello"}) {
+			t.Errorf("%s got %v", id, got)
+		}
+	}
+
+	time.Sleep(2 * reliable.AnnounceInterval) //Until a has heard where c is
+	outgoing <- note{To: "c", Text: "for c"}
+	if got := receive(t, notes["c"], "note at c"); got.Text != "for c" {
+		t.Errorf("c got %v", got)
+	}
+	select {
+	case got := <-notes["b"]:
+		t.Errorf("b got %v, meant for c", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,207 @@
+package conn
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Network impairment for testing. When ImpairmentEnabled is set, sockets from Dial pass every received
+// datagram through the impairment for its sender before handing it on.
+//
+// Impairments are written as space separated settings, where unset ones are off:
+//
+//	[peer=<ip, ip:port or :port>] loss=0.2 delay=100ms jitter=20ms duplicate=0.05 reorder=0.1 partition=true
+//
+// Without a peer the impairment applies to every sender that has none of its own. "reset" removes all.
+// A peer is told apart by the address its datagrams come from. Nodes send their broadcasts from the shared
+// ports, so nodes on the same host can only be impaired together, unless each is given a port of its own
+// with Config.SendPort. Then peer=:<port> impairs that node alone, whichever address it is reached on.
+// They can be changed while running with ServeImpairmentControl, for example from a test script with
+//
+//	echo "loss=0.5" | nc -u -w0 localhost 17000
+var ImpairmentEnabled = false
+
+type Impairment struct {
+	Loss        float64 //Share of datagrams dropped, 0 to 1
+	Delay       time.Duration
+	Jitter      time.Duration //Delay varies this much either way
+	Duplicate   float64       //Share of datagrams delivered twice
+	Reorder     float64       //Share of datagrams held back by reorderDelay, so later ones overtake them
+	Partitioned bool          //Drop everything
+}
+
+const (
+	reorderDelay = 25 * time.Millisecond //Longer than the send intervals, so the next datagram gets there first
+)
+
+var impairments = struct {
+	sync.Mutex
+	peers map[string]Impairment
+}{peers: make(map[string]Impairment)}
+
+func SetImpairment(peer string, impairment Impairment) {
+	impairments.Lock()
+	defer impairments.Unlock()
+	impairments.peers[peer] = impairment
+}
+
+func ResetImpairments() {
+	impairments.Lock()
+	defer impairments.Unlock()
+	impairments.peers = make(map[string]Impairment)
+}
+
+func impairmentFor(from net.Addr) Impairment {
+	impairments.Lock()
+	defer impairments.Unlock()
+	if from != nil {
+		if impairment, ok := impairments.peers[from.String()]; ok {
+			return impairment
+		}
+		if host, port, err := net.SplitHostPort(from.String()); err == nil {
+			if impairment, ok := impairments.peers[":"+port]; ok {
+				return impairment
+			}
+			if impairment, ok := impairments.peers[host]; ok {
+				return impairment
+			}
+		}
+	}
+	return impairments.peers[""]
+}
+
+// Applies one line in the format above
+func ApplyImpairment(command string) error {
+	switch strings.TrimSpace(command) {
+	case "":
+		return nil
+	case "reset":
+		ResetImpairments()
+		return nil
+	}
+	peer := ""
+	impairment := Impairment{}
+	for _, setting := range strings.Fields(command) {
+		key, value, found := strings.Cut(setting, "=")
+		if !found {
+			return fmt.Errorf("expected key=value, got %q", setting)
+		}
+		var err error
+		switch key {
+		case "peer":
+			peer = value
+		case "loss":
+			impairment.Loss, err = strconv.ParseFloat(value, 64)
+		case "delay":
+			impairment.Delay, err = time.ParseDuration(value)
+		case "jitter":
+			impairment.Jitter, err = time.ParseDuration(value)
+		case "duplicate":
+			impairment.Duplicate, err = strconv.ParseFloat(value, 64)
+		case "reorder":
+			impairment.Reorder, err = strconv.ParseFloat(value, 64)
+		case "partition":
+			impairment.Partitioned, err = strconv.ParseBool(value)
+		default:
+			return fmt.Errorf("unknown impairment %q", key)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	SetImpairment(peer, impairment)
+	logger.Info("Impairment set", "peer", peer, "impairment", fmt.Sprintf("%+v", impairment))
+	if peer != "" && !strings.Contains(peer, ":") {
+		logger.Warn("Every node on this host sends from the same address unless it has a send port, the impairment applies to all of them", "peer", peer)
+	}
+	return nil
+}
+
+// Listens for impairment commands on a local UDP port and answers each with "ok" or the error
+func ServeImpairmentControl(port int) {
+	conn, err := net.ListenPacket("udp4", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		logger.Error("ServeImpairmentControl failed", "err", err)
+		return
+	}
+	var buf [1024]byte
+	for {
+		n, from, err := conn.ReadFrom(buf[0:])
+		if err != nil {
+			continue
+		}
+		reply := "ok\n"
+		if err := ApplyImpairment(string(buf[:n])); err != nil {
+			reply = err.Error() + "\n"
+		}
+		conn.WriteTo([]byte(reply), from)
+	}
+}
+
+// A PacketConn whose reads come through the impairments
+type impairedConn struct {
+	net.PacketConn
+	*queue
+}
+
+func impair(conn net.PacketConn) net.PacketConn {
+	c := &impairedConn{PacketConn: conn, queue: newQueue()}
+	go c.receive()
+	return c
+}
+
+func (c *impairedConn) receive() {
+	var buf [65536]byte
+	for {
+		n, from, err := c.PacketConn.ReadFrom(buf[0:])
+		if err != nil {
+			c.close(err)
+			return
+		}
+		deliverImpaired(datagram{data: append([]byte(nil), buf[:n]...), from: from}, c.deliver)
+	}
+}
+
+// Hands the datagram to deliver as the impairment for its sender says: dropped, delayed or more than once
+func deliverImpaired(d datagram, deliver func(datagram)) {
+	impairment := impairmentFor(d.from)
+	if impairment.Partitioned || rand.Float64() < impairment.Loss {
+		return
+	}
+	copies := 1
+	if rand.Float64() < impairment.Duplicate {
+		copies = 2
+	}
+	for ; copies > 0; copies-- {
+		delay := impairment.Delay
+		if impairment.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(2*impairment.Jitter))) - impairment.Jitter
+		}
+		if rand.Float64() < impairment.Reorder {
+			delay += reorderDelay
+		}
+		if delay <= 0 {
+			deliver(d)
+		} else {
+			time.AfterFunc(delay, func() { deliver(d) })
+		}
+	}
+}
+
+func (c *impairedConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	return c.queue.ReadFrom(b)
+}
+
+func (c *impairedConn) SetReadDeadline(t time.Time) error {
+	return c.queue.SetReadDeadline(t)
+}
+
+func (c *impairedConn) SetDeadline(t time.Time) error {
+	c.queue.SetReadDeadline(t)
+	return c.PacketConn.SetWriteDeadline(t)
+}
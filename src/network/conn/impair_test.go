@@ -0,0 +1,59 @@
+package conn
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// With SendPort set, this node's datagrams come from that port, and an impairment for it leaves other
+// senders on the same host alone
+func TestImpairOneNodeBySendPort(t *testing.T) {
+	const listenPort, sendPort = 21913, 21914
+	saved, savedEnabled := Config, ImpairmentEnabled
+	t.Cleanup(func() {
+		Config, ImpairmentEnabled = saved, savedEnabled
+		ResetImpairments()
+	})
+	Config = Addressing{Mode: ModeUnicast, Peers: []string{"127.0.0.1"}, SendPort: sendPort}
+	ImpairmentEnabled = true
+
+	conn := Dial(listenPort)
+	other, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	to := Destinations(listenPort)[0]
+
+	read := func() (string, bool) {
+		var buf [64]byte
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, from, err := conn.ReadFrom(buf[:])
+		if err != nil {
+			return "", false
+		}
+		if string(buf[:n]) == "node" && from.(*net.UDPAddr).Port != sendPort {
+			t.Errorf("sent from %v, want port %d", from, sendPort)
+		}
+		return string(buf[:n]), true
+	}
+
+	conn.WriteTo([]byte("node"), to)
+	if got, ok := read(); !ok || got != "node" {
+		t.Fatalf("got %q, %v before impairing", got, ok)
+	}
+
+	if err := ApplyImpairment("peer=:" + strconv.Itoa(sendPort) + " partition=true"); err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteTo([]byte("node"), to)
+	other.WriteTo([]byte("other"), to)
+	if got, ok := read(); !ok || got != "other" {
+		t.Errorf("got %q, %v, want only the other sender", got, ok)
+	}
+	if got, ok := read(); ok {
+		t.Errorf("got %q from the impaired node", got)
+	}
+}
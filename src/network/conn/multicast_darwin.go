@@ -0,0 +1,13 @@
+//go:build darwin
+// +build darwin
+
+package conn
+
+import "syscall"
+
+func setMulticastOptions(descriptor uintptr, ttl int) error {
+	if err := syscall.SetsockoptByte(int(descriptor), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 1); err != nil {
+		return err
+	}
+	return syscall.SetsockoptByte(int(descriptor), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, byte(ttl))
+}
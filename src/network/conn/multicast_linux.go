@@ -0,0 +1,13 @@
+//go:build linux
+// +build linux
+
+package conn
+
+import "syscall"
+
+func setMulticastOptions(descriptor uintptr, ttl int) error {
+	if err := syscall.SetsockoptInt(int(descriptor), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 1); err != nil {
+		return err
+	}
+	return syscall.SetsockoptInt(int(descriptor), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl)
+}
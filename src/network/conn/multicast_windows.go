@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package conn
+
+import "syscall"
+
+func setMulticastOptions(descriptor uintptr, ttl int) error {
+	if err := syscall.SetsockoptInt(syscall.Handle(descriptor), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 1); err != nil {
+		return err
+	}
+	return syscall.SetsockoptInt(syscall.Handle(descriptor), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl)
+}
@@ -0,0 +1,77 @@
+package conn
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const queueLen = 256
+
+type datagram struct {
+	data []byte
+	from net.Addr
+}
+
+// Received datagrams waiting to be read, for sockets that are not read straight from the system.
+// Read deadlines are handled here.
+type queue struct {
+	incoming chan datagram
+	closed   chan struct{}
+	mu       sync.Mutex
+	deadline time.Time
+	err      error
+}
+
+func newQueue() *queue {
+	return &queue{incoming: make(chan datagram, queueLen), closed: make(chan struct{})}
+}
+
+// Drops the datagram if nobody is reading, like a full socket buffer would
+func (q *queue) deliver(d datagram) {
+	select {
+	case q.incoming <- d:
+	default:
+	}
+}
+
+// Makes every read from now on return err
+func (q *queue) close(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err == nil {
+		q.err = err
+		close(q.closed)
+	}
+}
+
+func (q *queue) ReadFrom(b []byte) (int, net.Addr, error) {
+	q.mu.Lock()
+	deadline := q.deadline
+	q.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case d := <-q.incoming:
+		return copy(b, d.data), d.from, nil
+	case <-q.closed:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return 0, nil, q.err
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (q *queue) SetReadDeadline(t time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.deadline = t
+	return nil
+}
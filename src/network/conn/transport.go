@@ -0,0 +1,77 @@
+package conn
+
+import (
+	"net"
+	"sync"
+)
+
+// Where bcast, peers and reliable get their sockets. UDP is the real network, a Hub connects
+// nodes running in the same process.
+type Transport interface {
+	Dial(port int) net.PacketConn     //Socket on port that receives what is sent to Destinations(port)
+	DialUnicast() net.PacketConn      //Socket on a port of its own
+	Destinations(port int) []net.Addr //Where to send so every node listening on port gets it
+	Done() <-chan struct{}            //Closed once the sockets are, nil if they never are
+}
+
+// The network, addressed as Config says
+type UDP struct{}
+
+func (UDP) Dial(port int) net.PacketConn     { return Dial(port) }
+func (UDP) DialUnicast() net.PacketConn      { return DialUnicast() }
+func (UDP) Destinations(port int) []net.Addr { return Destinations(port) }
+func (UDP) Done() <-chan struct{}            { return nil }
+
+// A transport whose sockets can all be closed at once, which stops everything running on it
+type Closable struct {
+	Transport
+	mu    sync.Mutex
+	conns []net.PacketConn
+	done  chan struct{}
+}
+
+func NewClosable(transport Transport) *Closable {
+	return &Closable{Transport: transport, done: make(chan struct{})}
+}
+
+func (c *Closable) Dial(port int) net.PacketConn {
+	return c.track(c.Transport.Dial(port))
+}
+
+func (c *Closable) DialUnicast() net.PacketConn {
+	return c.track(c.Transport.DialUnicast())
+}
+
+func (c *Closable) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Closable) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	close(c.done)
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+}
+
+func (c *Closable) track(conn net.PacketConn) net.PacketConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+		if conn != nil {
+			conn.Close()
+		}
+	default:
+		if conn != nil {
+			c.conns = append(c.conns, conn)
+		}
+	}
+	return conn
+}
@@ -1,20 +1,81 @@
 package localip
 
 import (
+	"errors"
 	"net"
-	"strings"
 )
 
-var localIP string
+// The local address is found from the network interfaces, without needing a network connection. In order of preference:
+//
+//	1. an address on the preferred interface, IPv4 before IPv6
+//	2. the first non-loopback IPv4 address
+//	3. the first non-loopback IPv6 address
+//	4. loopback, IPv4 before IPv6
+//
+// Only interfaces that are up count, and link-local addresses are skipped.
 
-func LocalIP() (string, error) {
-	if localIP == "" {
-		conn, err := net.DialTCP("tcp4", nil, &net.TCPAddr{IP: []byte{8, 8, 8, 8}, Port: 53})
+type Address struct {
+	IP        net.IP
+	Interface net.Interface
+}
+
+func Lookup(preferred string) (Address, error) {
+	if preferred != "" {
+		if _, err := net.InterfaceByName(preferred); err != nil {
+			return Address{}, err
+		}
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return Address{}, err
+	}
+
+	best, bestRank := Address{}, -1
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := ifi.Addrs()
 		if err != nil {
-			return "", err
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			if r := rank(ifi, ipNet.IP, preferred); bestRank < 0 || r < bestRank {
+				best, bestRank = Address{IP: ipNet.IP, Interface: ifi}, r
+			}
 		}
-		defer conn.Close()
-		localIP = strings.Split(conn.LocalAddr().String(), ":")[0]
 	}
-	return localIP, nil
+	if bestRank < 0 {
+		return Address{}, errors.New("no network interface with an address is up")
+	}
+	return best, nil
+}
+
+// Lower is better
+func rank(ifi net.Interface, ip net.IP, preferred string) int {
+	r := 0
+	if ip.To4() == nil {
+		r = 1
+	}
+	switch {
+	case preferred != "" && ifi.Name == preferred:
+		return r
+	case !ip.IsLoopback():
+		return 2 + r
+	default:
+		return 4 + r
+	}
+}
+
+// The local address as a string, from Lookup without a preferred interface
+func LocalIP() (string, error) {
+	addr, err := Lookup("")
+	if err != nil {
+		return "", err
+	}
+	return addr.IP.String(), nil
 }
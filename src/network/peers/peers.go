@@ -1,87 +1,228 @@
 package peers
 
 import (
+	"encoding/binary"
+	"heis/src/network/bcast"
 	"heis/src/network/conn"
-	"fmt"
-	"net"
 	"sort"
+	"strings"
 	"time"
 )
 
 type PeerUpdate struct {
-	Peers []string
-	New   string
-	Lost  []string
+	Peers        []string
+	New          string
+	Lost         []string
+	Capabilities map[string]Capabilities //Of every peer in Peers
 }
 
-const interval = 15 * time.Millisecond
-const timeout = 500 * time.Millisecond
+// What a node can do, told to the others in its heartbeats
+type Capabilities uint32
+
+const (
+	CapBinaryWire  Capabilities = 1 << iota //Sends its status in binary
+	CapReliable                             //Takes reliable unicast messages
+	CapCoordinator                          //Follows a coordinator
+)
+
+var capabilityNames = []string{"binary-wire", "reliable", "coordinator"}
+
+func (c Capabilities) String() string {
+	var names []string
+	for i, name := range capabilityNames {
+		if c&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// Heartbeats are `heartbeatMagic | protocol major | protocol minor | capabilities (4 bytes) | id | bank | fields added later`,
+// where id and bank are a uvarint length followed by the string. Before versioning they were only the id,
+// and nodes that send those are refused.
+const (
+	heartbeatMagic      = 0x9b
+	heartbeatHeaderSize = 7
+)
+
+// Sent in this node's heartbeats, set it before starting the Transmitter
+var LocalCapabilities Capabilities
+
+// Bank of this node, set it before starting the Transmitter and Receiver. Heartbeats from other banks are ignored,
+// so nodes that happen to share the peers port never count as alive.
+var Bank string
+
+// Set these before starting the Transmitter and Receiver.
+// A peer is lost when it has not been heard for Timeout. To keep a flapping peer from being
+// reported new and lost over and over, it is only new once it has been heard steadily for JoinDelay.
+var Interval = 15 * time.Millisecond
+var Timeout = 500 * time.Millisecond
+var JoinDelay = 1 * time.Second
+
+type peer struct {
+	heardSince time.Time //Start of the current run of heartbeats, each within Timeout of the last
+	lastSeen   time.Time
+	up         bool
+	caps       Capabilities
+}
 
 func Transmitter(port int, id string, transmitEnable <-chan bool) {
+	TransmitterOn(conn.UDP{}, port, id, transmitEnable)
+}
+
+// Like Transmitter, over the given transport
+func TransmitterOn(transport conn.Transport, port int, id string, transmitEnable <-chan bool) {
+
+	addrs := transport.Destinations(port)
+	conn := transport.Dial(port)
 
-	conn := conn.DialBroadcastUDP(port)
-	addr, _ := net.ResolveUDPAddr("udp4", fmt.Sprintf("10.100.23.11:%d", port))
+	heartbeat := []byte{heartbeatMagic, bcast.ProtocolMajor, bcast.ProtocolMinor}
+	heartbeat = binary.BigEndian.AppendUint32(heartbeat, uint32(LocalCapabilities))
+	heartbeat = appendString(heartbeat, id)
+	heartbeat = appendString(heartbeat, Bank)
 
 	enable := true
 	for {
 		select {
 		case enable = <-transmitEnable:
-		case <-time.After(interval):
+		case <-time.After(Interval):
+		case <-transport.Done():
+			return
 		}
 		if enable {
-			conn.WriteTo([]byte(id), addr)
+			for _, addr := range addrs {
+				conn.WriteTo(bcast.Seal(heartbeat), addr)
+			}
 		}
 	}
 }
 
 func Receiver(port int, peerUpdateCh chan<- PeerUpdate) {
+	ReceiverOn(conn.UDP{}, port, peerUpdateCh)
+}
+
+// Like Receiver, over the given transport
+func ReceiverOn(transport conn.Transport, port int, peerUpdateCh chan<- PeerUpdate) {
 
 	var buf [1024]byte
 	var p PeerUpdate
-	lastSeen := make(map[string]time.Time)
+	peers := make(map[string]*peer)
 
-	conn := conn.DialBroadcastUDP(port)
+	open := bcast.Opener(port)
+	conn := transport.Dial(port)
 
 	for {
 		updated := false
 
-		conn.SetReadDeadline(time.Now().Add(interval))
-		n, _, _ := conn.ReadFrom(buf[0:])
+		conn.SetReadDeadline(time.Now().Add(Interval))
+		n, from, _ := conn.ReadFrom(buf[0:])
+		select {
+		case <-transport.Done():
+			return
+		default:
+		}
 
-		id := string(buf[:n])
+		id := ""
+		var caps Capabilities
+		if n > 0 {
+			var bank string
+			id, bank, caps = parseHeartbeat(open(buf[:n], from.String()))
+			if bank != Bank {
+				id = ""
+			}
+		}
 
 		// Adding new connection
 		p.New = ""
 		if id != "" {
-			if _, idExists := lastSeen[id]; !idExists {
+			now := time.Now()
+			pr, exists := peers[id]
+			if !exists {
+				pr = &peer{heardSince: now}
+				peers[id] = pr
+			} else if now.Sub(pr.lastSeen) > Timeout {
+				pr.heardSince = now
+			}
+			pr.lastSeen = now
+			pr.caps = caps
+
+			if !pr.up && now.Sub(pr.heardSince) >= JoinDelay {
+				pr.up = true
 				p.New = id
 				updated = true
 			}
-
-			lastSeen[id] = time.Now()
 		}
 
 		// Removing dead connection
 		p.Lost = make([]string, 0)
-		for k, v := range lastSeen {
-			if time.Now().Sub(v) > timeout {
-				updated = true
-				p.Lost = append(p.Lost, k)
-				delete(lastSeen, k)
+		for k, v := range peers {
+			if time.Now().Sub(v.lastSeen) > Timeout {
+				if v.up {
+					updated = true
+					p.Lost = append(p.Lost, k)
+				}
+				delete(peers, k)
 			}
 		}
 
 		// Sending update
 		if updated {
-			p.Peers = make([]string, 0, len(lastSeen))
-
-			for k, _ := range lastSeen {
-				p.Peers = append(p.Peers, k)
+			p.Peers = make([]string, 0, len(peers))
+			p.Capabilities = make(map[string]Capabilities, len(peers))
+
+			for k, v := range peers {
+				if v.up {
+					p.Peers = append(p.Peers, k)
+					p.Capabilities[k] = v.caps
+				}
 			}
 
 			sort.Strings(p.Peers)
 			sort.Strings(p.Lost)
-			peerUpdateCh <- p
+			select {
+			case peerUpdateCh <- p:
+			case <-transport.Done():
+				return
+			}
 		}
 	}
 }
+
+// The id, bank and capabilities in a heartbeat, or an empty id if the heartbeat is from an incompatible node
+func parseHeartbeat(packet []byte) (string, string, Capabilities) {
+	if len(packet) == 0 {
+		return "", "", 0
+	}
+	if len(packet) <= heartbeatHeaderSize || packet[0] != heartbeatMagic {
+		bcast.Compatible(0, 0, "node "+string(packet))
+		return "", "", 0
+	}
+	if !bcast.Compatible(int(packet[1]), int(packet[2]), "node "+string(packet[heartbeatHeaderSize:])) {
+		return "", "", 0
+	}
+	id, rest, ok := readString(packet[heartbeatHeaderSize:])
+	if !ok {
+		return "", "", 0
+	}
+	bank, _, ok := readString(rest)
+	if !ok {
+		return "", "", 0
+	}
+	return id, bank, Capabilities(binary.BigEndian.Uint32(packet[3:7]))
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func readString(buf []byte) (string, []byte, bool) {
+	length, n := binary.Uvarint(buf)
+	if n <= 0 || length > uint64(len(buf)-n) {
+		return "", nil, false
+	}
+	return string(buf[n : n+int(length)]), buf[n+int(length):], true
+}
@@ -0,0 +1,390 @@
+package reliable
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"heis/src/logging"
+	"heis/src/network/bcast"
+	"heis/src/network/conn"
+	"math/rand"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Point-to-point messages with delivery guarantees, for what the best-effort broadcasts of bcast are not good enough.
+// Every node announces its ID and the port of its own data socket on the shared port, where conn.Config says,
+// and messages go straight to the data socket of the node they are for:
+//
+//	announceMagic | data port (2 bytes) | node ID
+//	dataMagic     | protocol major | protocol minor | sequence number (4 bytes) | sender ID | recipient ID | type-tagged JSON
+//	ackMagic      | sequence number (4 bytes) | ID of the node acking
+//
+// A message is sent again every RetransmitInterval until it is acked, at most MaxAttempts times. The recipient acks
+// every copy but delivers only the first. Messages from the same sender may be delivered out of order.
+// Messages from nodes with another protocol major version are not acked, so their senders give up.
+// Datagrams are authenticated with bcast.Seal, like the broadcasts.
+
+// Set these before calling New
+var RetransmitInterval = 100 * time.Millisecond
+var MaxAttempts = 20
+var AnnounceInterval = 500 * time.Millisecond
+
+const (
+	announceMagic = 0x4e
+	dataMagic     = 0xd4
+	ackMagic      = 0xac
+	maxPacketSize = 8192
+	incomingLen   = 64
+)
+
+var logger = logging.For(logging.Net).With("component", "reliable")
+
+// Implemented by the values sent through a Node, to tell which node gets them
+type Addressed interface {
+	Recipient() string
+}
+
+type Node struct {
+	id        string
+	port      int
+	data      net.PacketConn
+	mu        sync.Mutex
+	addrs     map[string]net.Addr //Data socket of every node that has announced itself
+	pending   map[uint32]*outgoing
+	delivered map[messageKey]time.Time
+	pruned    time.Time
+	nextSeq   uint32
+	incoming  chan []byte
+	done      <-chan struct{} //Of the transport, the node stops when it closes
+}
+
+type outgoing struct {
+	to       string
+	packet   []byte
+	attempts int
+	sent     time.Time
+}
+
+type messageKey struct {
+	sender string
+	seq    uint32
+}
+
+// Starts the node with ID id, announcing itself on port. Use Transmitter and Receiver to send and receive.
+func New(port int, id string) *Node {
+	return NewOn(conn.UDP{}, port, id)
+}
+
+// Like New, over the given transport
+func NewOn(transport conn.Transport, port int, id string) *Node {
+	n := &Node{
+		id:        id,
+		port:      port,
+		data:      transport.DialUnicast(),
+		addrs:     make(map[string]net.Addr),
+		pending:   make(map[uint32]*outgoing),
+		delivered: make(map[messageKey]time.Time),
+		nextSeq:   rand.Uint32(), //So a restarted node doesn't reuse numbers the others remember
+		incoming:  make(chan []byte, incomingLen),
+		done:      transport.Done(),
+	}
+	shared := transport.Dial(port)
+	go n.announce(shared, transport.Destinations(port))
+	go n.listenAnnouncements(shared)
+	go n.receive()
+	return n
+}
+
+// Sends the values received from `chans` to the node each of them names. The element types must implement Addressed.
+func (n *Node) Transmitter(chans ...interface{}) {
+	checkArgs(chans...)
+	typeNames := make([]string, len(chans))
+	selectCases := make([]reflect.SelectCase, len(chans)+2)
+	for i, ch := range chans {
+		if !reflect.TypeOf(ch).Elem().Implements(reflect.TypeOf((*Addressed)(nil)).Elem()) {
+			panic(fmt.Sprintf(
+				"Channel element type must implement reliable.Addressed, got '%s' instead (arg# %d)",
+				reflect.TypeOf(ch).Elem().String(), i+1))
+		}
+		selectCases[i] = reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(ch),
+		}
+		typeNames[i] = reflect.TypeOf(ch).Elem().String()
+	}
+	ticker := time.NewTicker(RetransmitInterval / 2)
+	selectCases[len(chans)] = reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(ticker.C),
+	}
+	selectCases[len(chans)+1] = reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(n.done),
+	}
+	defer ticker.Stop()
+
+	for {
+		chosen, value, _ := reflect.Select(selectCases)
+		if chosen == len(chans)+1 {
+			return
+		}
+		if chosen == len(chans) {
+			n.retransmit()
+			continue
+		}
+		jsonstr, err := json.Marshal(value.Interface())
+		if err != nil {
+			logger.Error("Marshal failed", "port", n.port, "err", err)
+			continue
+		}
+		payload, _ := json.Marshal(typeTaggedJSON{
+			TypeId: typeNames[chosen],
+			JSON:   jsonstr,
+		})
+		n.send(value.Interface().(Addressed).Recipient(), payload)
+	}
+}
+
+// Matches the messages sent to this node to element types of `chans`, then
+// sends the decoded value on the corresponding channel
+func (n *Node) Receiver(chans ...interface{}) {
+	checkArgs(chans...)
+	chansMap := make(map[string]interface{})
+	for _, ch := range chans {
+		chansMap[reflect.TypeOf(ch).Elem().String()] = ch
+	}
+
+	for {
+		var payload []byte
+		select {
+		case payload = <-n.incoming:
+		case <-n.done:
+			return
+		}
+		var ttj typeTaggedJSON
+		if err := json.Unmarshal(payload, &ttj); err != nil {
+			logger.Warn("Unmarshal failed", "port", n.port, "err", err)
+			continue
+		}
+		ch, ok := chansMap[ttj.TypeId]
+		if !ok {
+			continue
+		}
+		v := reflect.New(reflect.TypeOf(ch).Elem())
+		if err := json.Unmarshal(ttj.JSON, v.Interface()); err != nil {
+			logger.Warn("Unmarshal failed", "port", n.port, "err", err)
+			continue
+		}
+		reflect.Select([]reflect.SelectCase{
+			{Dir: reflect.SelectSend, Chan: reflect.ValueOf(ch), Send: reflect.Indirect(v)},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(n.done)},
+		})
+	}
+}
+
+func (n *Node) stopped() bool {
+	select {
+	case <-n.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Number of messages sent that have not been acked yet
+func (n *Node) Pending() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.pending)
+}
+
+func (n *Node) send(to string, payload []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	seq := n.nextSeq
+	n.nextSeq++
+	packet := binary.BigEndian.AppendUint32([]byte{dataMagic, bcast.ProtocolMajor, bcast.ProtocolMinor}, seq)
+	packet = appendString(packet, n.id)
+	packet = appendString(packet, to)
+	packet = append(packet, payload...)
+	if len(packet) > maxPacketSize {
+		logger.Error("Dropped message that is too long", "to", to, "length", len(packet), "max", maxPacketSize)
+		return
+	}
+	o := &outgoing{to: to, packet: packet}
+	n.pending[seq] = o
+	n.write(o, time.Now())
+}
+
+func (n *Node) retransmit() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	for seq, o := range n.pending {
+		if now.Sub(o.sent) < RetransmitInterval {
+			continue
+		}
+		if o.attempts >= MaxAttempts {
+			logger.Warn("Gave up on message", "seq", seq, "to", o.to, "attempts", o.attempts)
+			delete(n.pending, seq)
+			continue
+		}
+		n.write(o, now)
+	}
+}
+
+// Counts as an attempt even when the recipient hasn't announced itself yet. Call with mu held.
+func (n *Node) write(o *outgoing, now time.Time) {
+	o.attempts++
+	o.sent = now
+	if addr, ok := n.addrs[o.to]; ok {
+		n.data.WriteTo(bcast.Seal(o.packet), addr)
+	}
+}
+
+func (n *Node) announce(shared net.PacketConn, addrs []net.Addr) {
+	packet := binary.BigEndian.AppendUint16([]byte{announceMagic}, uint16(n.data.LocalAddr().(*net.UDPAddr).Port))
+	packet = append(packet, n.id...)
+	for {
+		for _, addr := range addrs {
+			shared.WriteTo(bcast.Seal(packet), addr)
+		}
+		select {
+		case <-time.After(AnnounceInterval):
+		case <-n.done:
+			return
+		}
+	}
+}
+
+func (n *Node) listenAnnouncements(shared net.PacketConn) {
+	var buf [1024]byte
+	open := bcast.Opener(n.port)
+	for {
+		length, from, err := shared.ReadFrom(buf[0:])
+		if err != nil {
+			if n.stopped() {
+				return
+			}
+			logger.Error("ReadFrom failed", "port", n.port, "err", err)
+			continue
+		}
+		packet := open(buf[:length], from.String())
+		udpFrom, ok := from.(*net.UDPAddr)
+		if len(packet) < 4 || packet[0] != announceMagic || !ok {
+			continue
+		}
+		addr := &net.UDPAddr{IP: udpFrom.IP, Port: int(binary.BigEndian.Uint16(packet[1:3]))}
+		n.mu.Lock()
+		n.addrs[string(packet[3:])] = addr
+		n.mu.Unlock()
+	}
+}
+
+func (n *Node) receive() {
+	var buf [65536]byte
+	open := bcast.Opener(n.port)
+	for {
+		length, from, err := n.data.ReadFrom(buf[0:])
+		if err != nil {
+			if n.stopped() {
+				return
+			}
+			logger.Error("ReadFrom failed", "port", n.port, "err", err)
+			continue
+		}
+		packet := open(buf[:length], from.String())
+		switch {
+		case len(packet) >= 5 && packet[0] == ackMagic:
+			seq := binary.BigEndian.Uint32(packet[1:5])
+			n.mu.Lock()
+			if o, ok := n.pending[seq]; ok && o.to == string(packet[5:]) {
+				delete(n.pending, seq)
+			}
+			n.mu.Unlock()
+		case len(packet) >= 7 && packet[0] == dataMagic:
+			seq := binary.BigEndian.Uint32(packet[3:7])
+			sender, rest, ok := readString(packet[7:])
+			if !ok || !bcast.Compatible(int(packet[1]), int(packet[2]), "node "+sender) {
+				continue
+			}
+			recipient, payload, ok := readString(rest)
+			if !ok || recipient != n.id {
+				continue
+			}
+			if n.deliver(messageKey{sender: sender, seq: seq}, payload) {
+				ack := binary.BigEndian.AppendUint32([]byte{ackMagic}, seq)
+				n.data.WriteTo(bcast.Seal(append(ack, n.id...)), from)
+			}
+		}
+	}
+}
+
+// Hands the payload to Receiver unless it has been already. False if it couldn't be, then it is not acked
+// and the sender tries again.
+func (n *Node) deliver(key messageKey, payload []byte) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	dedupWindow := 2 * time.Duration(MaxAttempts) * RetransmitInterval //Well past the last resend of a message
+	if now.Sub(n.pruned) > dedupWindow {
+		for k, delivered := range n.delivered {
+			if now.Sub(delivered) > dedupWindow {
+				delete(n.delivered, k)
+			}
+		}
+		n.pruned = now
+	}
+	if _, seen := n.delivered[key]; seen {
+		return true
+	}
+	select {
+	case n.incoming <- append([]byte(nil), payload...):
+		n.delivered[key] = now
+		return true
+	default:
+		return false
+	}
+}
+
+type typeTaggedJSON struct {
+	TypeId string
+	JSON   []byte
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func readString(buf []byte) (string, []byte, bool) {
+	length, n := binary.Uvarint(buf)
+	if n <= 0 || length > uint64(len(buf)-n) {
+		return "", nil, false
+	}
+	return string(buf[n : n+int(length)]), buf[n+int(length):], true
+}
+
+// Checks that the args are channels with mutually different element types
+func checkArgs(chans ...interface{}) {
+	elemTypes := make([]reflect.Type, len(chans))
+	for i, ch := range chans {
+		if reflect.ValueOf(ch).Kind() != reflect.Chan {
+			panic(fmt.Sprintf(
+				"Argument must be a channel, got '%s' instead (arg# %d)",
+				reflect.TypeOf(ch).String(), i+1))
+		}
+		elemType := reflect.TypeOf(ch).Elem()
+		for j, e := range elemTypes {
+			if e == elemType {
+				panic(fmt.Sprintf(
+					"All channels must have mutually different element types, arg# %d and arg# %d both have element type '%s'",
+					j+1, i+1, e.String()))
+			}
+		}
+		elemTypes[i] = elemType
+	}
+}
@@ -0,0 +1,395 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	cost "heis/src/cost_func"
+	"heis/src/elev"
+	"heis/src/elevio"
+	"heis/src/logging"
+	"heis/src/metrics"
+	"heis/src/network/bcast"
+	"heis/src/network/conn"
+	"heis/src/network/peers"
+	"heis/src/network/reliable"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+const numFloors = 4
+
+// One elevator node. main fills it in from the flags, tests can run several on a conn.Hub.
+// Nodes in one process share the settings of the network packages and logging, so they need the same bank and options.
+type Config struct {
+	ID               string
+	Instance         uint32 //Tells restarts of the node apart
+	Server           string //Elevator server address
+	Bank             string
+	BcastPort        int            //Peers and reliable messages use ports above it
+	Transport        conn.Transport //Nil for UDP
+	Assigner         string
+	Hysteresis       time.Duration
+	Coordinator      bool
+	DestinationPanel io.Reader //Nil for none
+	CarLetter        string
+	HRARecordFile    string
+	MetricsAddr      string
+}
+
+func (c Config) Validate() error {
+	if _, err := cost.NewAssigner(c.Assigner, cost.DefaultHRAConfig); err != nil {
+		return err
+	}
+	if slices.Contains(cost.CoordinatorAssigners, c.Assigner) && !c.Coordinator {
+		return fmt.Errorf("-assigner %s needs -coordinator, nodes assigning for themselves would disagree", c.Assigner)
+	}
+	if c.Hysteresis > 0 && !c.Coordinator {
+		return errors.New("-hysteresis needs -coordinator, nodes assigning for themselves would keep orders with different elevators")
+	}
+	return nil
+}
+
+// Sets what the nodes in this process share, call it once before Run
+func Setup(config Config) {
+	elevio.NumFloors = numFloors
+	peers.Bank = config.Bank
+	peers.LocalCapabilities = peers.CapReliable
+	if bcast.BinaryEncoding {
+		peers.LocalCapabilities |= peers.CapBinaryWire
+	}
+	if config.Coordinator {
+		peers.LocalCapabilities |= peers.CapCoordinator
+	}
+	logging.For(logging.Main).Info("Protocol", "version", fmt.Sprintf("%d.%d", bcast.ProtocolMajor, bcast.ProtocolMinor), "capabilities", peers.LocalCapabilities.String())
+}
+
+// Runs the node until ctx is done, then closes its sockets and the elevator connection. Panics on a bad config, like main.
+func Run(ctx context.Context, config Config) {
+	if err := config.Validate(); err != nil {
+		panic(err.Error())
+	}
+	logger := logging.For(logging.Main)
+	nodeID := config.ID
+	instance := config.Instance
+	if config.Transport == nil {
+		config.Transport = conn.UDP{}
+	}
+	transport := conn.NewClosable(config.Transport)
+	defer transport.Close()
+
+	otherNodesMap := make(map[string]elev.ElevatorMessage) //Map to store messages from other nodes
+	var alivePeers []string                                //Latest peer list from the peers package
+	var peerCapabilities map[string]peers.Capabilities     //Of the peers in alivePeers
+	online := true                                         //False while stuck, the node neither sends nor listens then
+
+	doorTimeOpen := 3 * time.Second
+	doorTimer := time.NewTimer(doorTimeOpen)
+	doorTimer.Stop()
+
+	obstructionLimit := 8 * time.Second
+	doorObstructedTimer := time.NewTimer(obstructionLimit)
+	doorObstructedTimer.Stop()
+
+	lastFloorChangeTime := time.Now()
+	motorWatchdog := time.NewTicker(1 * time.Second)
+	defer motorWatchdog.Stop()
+
+	sendTicker := time.NewTicker(10 * time.Millisecond) //Status repeat interval
+	defer sendTicker.Stop()
+	statusSender := &elev.StatusSender{}
+
+	hraConfig := cost.HRAConfig{DoorOpenDuration: doorTimeOpen, TravelDuration: cost.DefaultHRAConfig.TravelDuration} //Until the elevators have measured their own
+	primaryAssigner, err := cost.NewAssigner(config.Assigner, hraConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+	fallbackAssigner := cost.NewFallbackAssigner(primaryAssigner)
+	var assigner cost.Assigner = fallbackAssigner
+	if config.Hysteresis > 0 {
+		assigner = cost.NewHysteresis(assigner, hraConfig, config.Hysteresis)
+	}
+
+	var hraRecorder *json.Encoder
+	if config.HRARecordFile != "" {
+		file, err := os.OpenFile(config.HRARecordFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			panic(err.Error())
+		}
+		defer file.Close()
+		hraRecorder = json.NewEncoder(file)
+	}
+
+	assignmentTicker := time.NewTicker(100 * time.Millisecond) //Coordinator resend interval
+	defer assignmentTicker.Stop()
+
+	peersPort := config.BcastPort + 2000 //Outside the range of bank ports
+	logger.Info("Bank", "bank", config.Bank, "port", config.BcastPort)
+	bus := bcast.OpenOn(transport, config.BcastPort)
+	statusTopic := bcast.NewTopic[elev.ElevatorMessage](bus, "status")
+	assignmentTopic := bcast.NewTopic[elev.AssignmentMessage](bus, "assignment")
+
+	logger.Info("Starting", "server", config.Server)
+	driver := elevio.Connect(config.Server)
+	defer driver.Close()
+
+	elevator := &elev.Elevator{Driver: driver}
+	elevator.CabInit(nodeID, numFloors)
+	elevator.OtherNodes.Bank = config.Bank
+	elevator.OtherNodes.Instance = instance
+	elevator.OtherNodes.CarLetter = config.CarLetter
+	var lastDuplicateWarning time.Time
+	instances := make(map[string]uint32) //Last instance heard from every node, kept while it is lost, to tell a restart apart
+	coordinator := cost.NewCoordinator(nodeID, config.Bank, instance)
+	foreignNodes := make(map[string]bool) //Nodes from other banks that have been reported
+
+	peerUpdates := make(chan peers.PeerUpdate)
+	peersEnable := make(chan bool)
+	go peers.TransmitterOn(transport, peersPort, nodeID, peersEnable)
+	go peers.ReceiverOn(transport, peersPort, peerUpdates)
+
+	cabRecoveryOut := make(chan elev.CabRecoveryMessage)
+	cabRecoveryIn := make(chan elev.CabRecoveryMessage)
+	reliableNode := reliable.NewOn(transport, config.BcastPort+3000, nodeID) //Above the peers ports
+	go reliableNode.Transmitter(cabRecoveryOut)
+	go reliableNode.Receiver(cabRecoveryIn)
+
+	nodeMetrics := metrics.NewNode()
+	nodeMetrics.WatchNetwork(bus, reliableNode)
+	lastHeard := make(map[string]time.Time) //When the last status message from each node arrived
+	if config.MetricsAddr != "" {
+		go metrics.Serve(config.MetricsAddr, nodeMetrics.Registry)
+	}
+
+	buttonEvents := make(chan elevio.ButtonEvent)
+	floorEvents := make(chan int)
+	obstructionEvents := make(chan bool)
+	stopEvents := make(chan bool)
+	buttonPressCh := make(chan bool, 1)
+	activeOrders := make(chan bool, 1) //Latest elevator.ActiveOrders for the floor sensor polling
+	lastActiveOrders := false
+	destinationRequests := make(chan elev.DestinationRequest)
+	var destinationCallers []destinationCaller //Callers told which car to take, or waiting to hear it
+
+	go driver.PollButtons(buttonEvents)
+	go driver.PollFloorSensor(floorEvents, buttonPressCh, activeOrders)
+	go driver.PollObstructionSwitch(obstructionEvents)
+	go driver.PollStopButton(stopEvents)
+	if config.DestinationPanel != nil {
+		go elev.DestinationPanel(config.DestinationPanel, destinationRequests)
+	}
+
+	for {
+		runCost := false //Flag to run cost function
+
+		select {
+		case <-ctx.Done():
+			return
+		case buttonEvent := <-buttonEvents:
+			elevator.UpdateElevatorOrder(buttonEvent)
+			buttonPressCh <- true
+			elevator.GoingWrongway(&buttonEvent)
+
+			runCost = true
+		case request := <-destinationRequests:
+			elevator.AddDestinationOrder(request.Origin, request.Destination)
+			destinationCallers = append(destinationCallers, destinationCaller{DestinationRequest: request})
+			runCost = true
+		case newFloor := <-floorEvents:
+			if newFloor != elevator.State.Floor {
+				lastFloorChangeTime = time.Now()
+				if elevator.State.Stuck {
+					elevator.State.Stuck = false
+					logger.Info("Motor drive recovered", "floor", newFloor)
+				}
+			}
+			driver.SetFloorIndicator(newFloor)
+			elevator.UpdateFloor(newFloor)
+
+			if !elevator.State.DoorOpen {
+				elevator.ExecuteOrder()
+				if elevator.State.DoorOpen {
+					logger.Info("Door opening", "floor", elevator.State.Floor)
+					doorTimer.Reset(doorTimeOpen)
+				}
+			}
+			runCost = true
+
+		case <-doorTimer.C:
+			elevator.DoorTimeHandler(doorTimer, doorTimeOpen)
+			runCost = true
+
+		case <-sendTicker.C:
+			if online == elevator.State.Stuck { //A stuck node goes offline, so the others take over its hall orders
+				online = !elevator.State.Stuck
+				peersEnable <- online
+				if online {
+					elevator.SetAlive(alivePeers, otherNodesMap)
+				} else {
+					elevator.SetAlive(nil, otherNodesMap)
+				}
+				runCost = true
+			}
+		case msg := <-statusTopic.Recv():
+			if msg.Bank != config.Bank {
+				if !foreignNodes[msg.Bank+"/"+msg.SenderID] {
+					foreignNodes[msg.Bank+"/"+msg.SenderID] = true
+					logger.Warn("Ignoring node from another bank", "peer", msg.SenderID, "bank", msg.Bank)
+				}
+				continue
+			}
+			if elevator.DuplicateID(msg) && time.Since(lastDuplicateWarning) > time.Second {
+				lastDuplicateWarning = time.Now()
+				logger.Error("!!! Another node is also using this ID, give every node its own -id !!!", "id", nodeID, "other_incarnation", msg.Instance)
+			}
+			previous := otherNodesMap[msg.SenderID]
+			lastInstance, known := instances[msg.SenderID]
+			restarted := known && msg.Instance != lastInstance //Message IDs start over on a restart
+			if (msg.SenderID == nodeID) || !elevator.OtherNodes.Alive[msg.SenderID] || (msg.MessageID <= previous.MessageID && !restarted) || elevator.State.Stuck {
+				continue
+			}
+
+			if backup, exists := elevator.Orders.CabBackupList[msg.SenderID]; restarted && exists && peerCapabilities[msg.SenderID]&peers.CapReliable != 0 {
+				cabRecoveryOut <- elev.CabRecoveryMessage{SenderID: nodeID, To: msg.SenderID, Cab: append([]elev.OrderStatus(nil), backup...)}
+			}
+
+			if restarted {
+				elevator.NodeRestarted(msg.SenderID)
+			}
+			instances[msg.SenderID] = msg.Instance
+			lastHeard[msg.SenderID] = time.Now()
+			stateChanged := elevator.StateChanged(msg, otherNodesMap)
+
+			otherNodesMap[msg.SenderID] = msg
+			elevator.CabBackupFunc(msg)
+			elevator.HallConsensus(msg, otherNodesMap)
+
+			if stateChanged {
+				runCost = true
+			}
+		case msg := <-cabRecoveryIn:
+			if !elevator.State.Stuck && elevator.RecoverCabOrders(msg) {
+				runCost = true
+			}
+		case msg := <-assignmentTopic.Recv():
+			if config.Coordinator && !elevator.State.Stuck && coordinator.Receive(msg, elevator.OtherNodes.Alive) {
+				runCost = true
+			}
+		case <-assignmentTicker.C:
+			if latest, ok := coordinator.Latest(elevator.OtherNodes.Alive); config.Coordinator && ok && coordinator.IsLeader(elevator.OtherNodes.Alive) {
+				assignmentTopic.Send(latest)
+			}
+		case update := <-peerUpdates:
+			alivePeers = update.Peers
+			peerCapabilities = update.Capabilities
+			if update.New != "" && update.New != nodeID {
+				logger.Info("Node capabilities", "peer", update.New, "capabilities", update.Capabilities[update.New].String())
+				if config.Coordinator && update.Capabilities[update.New]&peers.CapCoordinator == 0 {
+					logger.Warn("Node does not follow a coordinator, it will compute its own assignment", "peer", update.New)
+				}
+			}
+			if online && elevator.SetAlive(alivePeers, otherNodesMap) {
+				runCost = true
+			}
+		case <-doorObstructedTimer.C:
+			if elevator.State.Obstructed && elevator.State.DoorOpen {
+				logger.Warn("Door stuck due to obstruction", "floor", elevator.State.Floor)
+				elevator.State.Stuck = true
+				elevator.SetElevMotorDirection(elevio.MD_Stop)
+			}
+		case <-motorWatchdog.C:
+			elevator.StuckHandler(&lastFloorChangeTime)
+
+		case obstruction := <-obstructionEvents:
+			elevator.ObstructionHandler(obstruction, doorObstructedTimer, obstructionLimit, doorTimer, doorTimeOpen)
+
+		case stopPressed := <-stopEvents:
+			if stopPressed {
+				driver.SetStopLamp(true)
+				elevator.CabInit(nodeID, numFloors)
+				if online {
+					elevator.SetAlive(alivePeers, otherNodesMap)
+				}
+				driver.SetStopLamp(false)
+			}
+		}
+		elevator.UpdateOrderTimes()
+		nodeMetrics.ObserveElevator(elevator)
+		nodeMetrics.ObservePeers(elevator.OtherNodes.Alive, lastHeard)
+		if runCost {
+			hraInput := cost.MakeHRAInput(*elevator, otherNodesMap)
+			if hraRecorder != nil {
+				hraRecorder.Encode(hraInput)
+			}
+			var assignment map[string][][2]bool
+			var destAssignment map[string][][]bool
+			latest, following := coordinator.Latest(elevator.OtherNodes.Alive)
+			if config.Coordinator && following && !coordinator.IsLeader(elevator.OtherNodes.Alive) {
+				assignment, destAssignment = latest.Assigned, latest.AssignedDest
+			} else {
+				start := time.Now()
+				assignment = assigner.Assign(hraInput)
+				nodeMetrics.Assigned(time.Since(start), fallbackAssigner.Health())
+				destAssignment = cost.AssignDestinations(hraInput, assignment, hraConfig)
+				if config.Coordinator && coordinator.IsLeader(elevator.OtherNodes.Alive) && assignment != nil {
+					assignmentTopic.Send(coordinator.Publish(assignment, destAssignment))
+				}
+			}
+			elevator.SetAssignment(assignment[nodeID], destAssignment[nodeID])
+			destinationCallers = replyDestinations(destinationCallers, destAssignment, cost.CarLetters(*elevator, otherNodesMap))
+			elevator.UpdateHallLights()
+		}
+		if active := elevator.ActiveOrders(); active != lastActiveOrders {
+			select {
+			case <-activeOrders: //Replaced by the newer value
+			default:
+			}
+			activeOrders <- active
+			lastActiveOrders = active
+		}
+		if !elevator.State.Stuck {
+			statusSender.Send(elevator, nodeID, statusTopic.Send)
+		}
+	}
+}
+
+// A destination request and the letter of the car its caller was told to take, empty until then
+type destinationCaller struct {
+	elev.DestinationRequest
+	letter string
+}
+
+// Tells every caller whose destination order has been assigned which car to take, and tells them again if the order
+// has moved to another car, which AssignDestinations only does when the first car is unavailable.
+// A caller whose order is no longer assigned has been picked up, its Reply is closed.
+func replyDestinations(callers []destinationCaller, destAssignment map[string][][]bool, letters map[string]string) []destinationCaller {
+	if destAssignment == nil { //No assignment this time, not a sign that anyone was picked up
+		return callers
+	}
+	remaining := callers[:0]
+	for _, caller := range callers {
+		assignedTo := ""
+		for id, assigned := range destAssignment {
+			if assigned[caller.Origin][caller.Destination] {
+				assignedTo = id
+				break
+			}
+		}
+		switch {
+		case assignedTo == "" && caller.letter != "":
+			close(caller.Reply)
+			continue
+		case assignedTo != "" && letters[assignedTo] != caller.letter:
+			if caller.letter != "" {
+				logging.For(logging.Main).Info("Destination order moved, its car is unavailable", "origin", caller.Origin, "destination", caller.Destination, "from", caller.letter, "to", letters[assignedTo])
+			}
+			caller.letter = letters[assignedTo]
+			caller.Reply <- caller.letter
+		}
+		remaining = append(remaining, caller)
+	}
+	return remaining
+}
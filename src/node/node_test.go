@@ -0,0 +1,240 @@
+package node
+
+import (
+	"context"
+	"heis/src/elevio"
+	"heis/src/logging"
+	"heis/src/network/conn"
+	"heis/src/network/peers"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// An elevator server with a car that moves half a floor every tick. It takes a new connection
+// when the node restarts, and the car stays where it was.
+type fakeElevator struct {
+	mu       sync.Mutex
+	position int //In half floors from the bottom
+	motor    int8
+	frozen   bool //The car stays where it is whatever the motor does
+	pressed  [numFloors][3]bool
+	lamps    [numFloors][3]bool
+	lit      [numFloors][3]bool //Lamps that have been turned on
+	doorOpen bool
+	served   chan int //Floor the door opened at
+	addr     string
+}
+
+const fakeTick = 100 * time.Millisecond
+
+func startFakeElevator(t *testing.T) *fakeElevator {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeElevator{served: make(chan int, 16), addr: listener.Addr().String()}
+	ticker := time.NewTicker(fakeTick)
+	t.Cleanup(func() {
+		listener.Close()
+		ticker.Stop()
+	})
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	go func() {
+		for range ticker.C {
+			f.mu.Lock()
+			if !f.frozen {
+				f.position = min(max(f.position+int(f.motor), 0), 2*(numFloors-1))
+			}
+			f.mu.Unlock()
+		}
+	}()
+	return f
+}
+
+func (f *fakeElevator) serve(c net.Conn) {
+	defer c.Close()
+	var in [4]byte
+	for {
+		if _, err := io.ReadFull(c, in[:]); err != nil {
+			return
+		}
+		f.mu.Lock()
+		out := [4]byte{in[0], 0, 0, 0}
+		switch in[0] {
+		case 1:
+			f.motor = int8(in[1])
+		case 2:
+			f.lamps[in[2]][in[1]] = in[3] != 0
+			f.lit[in[2]][in[1]] = f.lit[in[2]][in[1]] || in[3] != 0
+		case 4:
+			if in[1] != 0 && !f.doorOpen && f.position%2 == 0 {
+				select {
+				case f.served <- f.position / 2:
+				default:
+				}
+			}
+			f.doorOpen = in[1] != 0
+		case 6:
+			out[1] = toByte(f.pressed[in[2]][in[1]])
+		case 7:
+			out[1], out[2] = toByte(f.position%2 == 0), byte(f.position/2)
+		}
+		f.mu.Unlock()
+		if in[0] >= 6 {
+			c.Write(out[:])
+		}
+	}
+}
+
+func (f *fakeElevator) press(t *testing.T, floor int, button elevio.ButtonType) {
+	f.mu.Lock()
+	f.pressed[floor][button] = true
+	f.mu.Unlock()
+	eventually(t, "the button lamp to light", func() bool { return f.hasLit(floor, button) })
+	f.mu.Lock()
+	f.pressed[floor][button] = false
+	f.mu.Unlock()
+}
+
+func (f *fakeElevator) setFrozen(frozen bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.frozen = frozen
+}
+
+func (f *fakeElevator) clearLamps() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lamps = [numFloors][3]bool{}
+}
+
+func (f *fakeElevator) lamp(floor int, button elevio.ButtonType) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lamps[floor][button]
+}
+
+func (f *fakeElevator) hasLit(floor int, button elevio.ButtonType) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lit[floor][button]
+}
+
+func toByte(value bool) byte {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// True if the door has opened at floor since the last call
+func (f *fakeElevator) servedAt(floor int) bool {
+	for {
+		select {
+		case served := <-f.served:
+			if served == floor {
+				return true
+			}
+		default:
+			return false
+		}
+	}
+}
+
+// Waits for done to return true, failing the test after 10 seconds
+func eventually(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(fakeTick / 4)
+	}
+}
+
+// Sets up what the nodes share once, as goroutines of a stopped node may still read it
+func TestMain(m *testing.M) {
+	logging.Setup(io.Discard, "text", slog.LevelError, "")
+	joinDelay := peers.JoinDelay
+	peers.JoinDelay = 50 * time.Millisecond
+	Setup(Config{})
+	code := m.Run()
+	peers.JoinDelay = joinDelay
+	os.Exit(code)
+}
+
+// Runs a node on the hub until the returned stop is called or the test ends
+func startNode(t *testing.T, hub *conn.Hub, id string, instance uint32, f *fakeElevator) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		Run(ctx, Config{ID: id, Instance: instance, Server: f.addr, BcastPort: 25013, Transport: hub.Node(), Assigner: "reassign"})
+	}()
+	stop = func() {
+		cancel()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Errorf("node %s did not stop", id)
+		}
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+// Two nodes on a hub agree on a hall order, light it on both elevators, and one of them serves it
+func TestTwoNodesServeHallOrder(t *testing.T) {
+	hub := conn.NewHub()
+	a, b := startFakeElevator(t), startFakeElevator(t)
+	startNode(t, hub, "a", 1, a)
+	startNode(t, hub, "b", 1, b)
+
+	a.press(t, 2, elevio.BT_HallUp)
+
+	eventually(t, "the hall order to light on both elevators", func() bool {
+		return a.hasLit(2, elevio.BT_HallUp) && b.hasLit(2, elevio.BT_HallUp)
+	})
+	eventually(t, "an elevator to open its door at floor 2", func() bool {
+		return a.servedAt(2) || b.servedAt(2)
+	})
+}
+
+// A node that restarts before serving its cab order gets it back from the other node's backup, and serves it
+func TestRestartedNodeRecoversCabOrders(t *testing.T) {
+	hub := conn.NewHub()
+	a, b := startFakeElevator(t), startFakeElevator(t)
+	stopA := startNode(t, hub, "a", 1, a)
+	startNode(t, hub, "b", 1, b)
+	a.setFrozen(true) //So no order is served before a stops
+	b.setFrozen(true)
+	a.press(t, 1, elevio.BT_HallDown)
+	eventually(t, "the nodes to see each other", func() bool { return b.hasLit(1, elevio.BT_HallDown) })
+
+	a.press(t, 3, elevio.BT_Cab)
+	stopA()
+	a.clearLamps()
+	a.setFrozen(false)
+	b.setFrozen(false)
+	if a.servedAt(3) {
+		t.Fatal("the order was served before the restart")
+	}
+
+	startNode(t, hub, "a", 2, a)
+	eventually(t, "the cab order to come back", func() bool { return a.lamp(3, elevio.BT_Cab) })
+	eventually(t, "the restarted node to serve its cab order", func() bool { return a.servedAt(3) })
+}
@@ -12,6 +12,6 @@ sleep 2
 go build -o heis main.go
 
 # Start the elevators in new terminal windows
-gnome-terminal --title="Heis 1" -- bash -c "./heis -port 15657; exec bash"
-gnome-terminal --title="Heis 2" -- bash -c "./heis -port 15656; exec bash"
-gnome-terminal --title="Heis 3" -- bash -c "./heis -port 15655; exec bash"
+gnome-terminal --title="Heis 1" -- bash -c "./heis -port 15657 -net-send-port 16657; exec bash"
+gnome-terminal --title="Heis 2" -- bash -c "./heis -port 15656 -net-send-port 16656; exec bash"
+gnome-terminal --title="Heis 3" -- bash -c "./heis -port 15655 -net-send-port 16655; exec bash"